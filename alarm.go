@@ -0,0 +1,122 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Alarm limits. A channel is in alarm while the average of its last
+// alarmWindow values is outside [low, high], so a single spike does not
+// raise an alarm with a window above 1. The stored values are unaffected.
+var (
+	alarmLow           = math.Inf(-1)
+	alarmHigh          = math.Inf(1)
+	alarmWindow        = 1
+	alarmLowByAddress  = make(map[byte]float64)
+	alarmHighByAddress = make(map[byte]float64)
+	alarmWindowByAddr  = make(map[byte]int)
+)
+
+// Alarm state per address
+var (
+	alarmSamples [MAXNUMADR][]float64 // last values, oldest first
+	alarmActive  [MAXNUMADR]bool
+)
+
+// alarmLimits returns the limits and window of the address at index idx
+func alarmLimits(idx int) (low, high float64, window int) {
+	adr := scanAddress[idx]
+	low, high, window = alarmLow, alarmHigh, alarmWindow
+	if v, ok := alarmLowByAddress[adr]; ok {
+		low = v
+	}
+	if v, ok := alarmHighByAddress[adr]; ok {
+		high = v
+	}
+	if v, ok := alarmWindowByAddr[adr]; ok {
+		window = v
+	}
+	return low, high, window
+}
+
+// checkAlarm adds a value of the address at index idx to its window and
+// raises or clears the alarm on the window average. Status codes and non
+// numeric values are ignored.
+func checkAlarm(idx int, value string) {
+	low, high, window := alarmLimits(idx)
+	if math.IsInf(low, -1) && math.IsInf(high, 1) || isStatusCode(value) {
+		return
+	}
+	v, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+	if err != nil {
+		return
+	}
+
+	samples := append(alarmSamples[idx], v)
+	if len(samples) > window {
+		samples = samples[len(samples)-window:]
+	}
+	alarmSamples[idx] = samples
+
+	var sum float64
+	for _, s := range samples {
+		sum += s
+	}
+	avg := sum / float64(len(samples))
+
+	adr := scanAddress[idx]
+	alarm := avg < low || avg > high
+	switch {
+	case alarm && !alarmActive[idx]:
+		slog.Warn("alarm raised", "address", adr, "average", avg, "samples", len(samples), "low", low, "high", high)
+	case !alarm && alarmActive[idx]:
+		slog.Info("alarm cleared", "address", adr, "average", avg, "samples", len(samples))
+	}
+	alarmActive[idx] = alarm
+}
+
+// setAlarmKey applies the "alarm.low", "alarm.high" and "alarm.window"
+// config entries and their per-address variants
+func setAlarmKey(key, value string) error {
+	name, adrStr, perAddress := strings.Cut(strings.TrimPrefix(key, "alarm."), ".")
+	var adr byte
+	if perAddress {
+		a, err := strconv.ParseUint(adrStr, 10, 8)
+		if err != nil {
+			return fmt.Errorf("invalid address in %q", key)
+		}
+		adr = byte(a)
+	}
+
+	if name == "window" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 {
+			return fmt.Errorf("invalid %s %q", key, value)
+		}
+		if perAddress {
+			alarmWindowByAddr[adr] = n
+		} else {
+			alarmWindow = n
+		}
+		return nil
+	}
+
+	limit, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return fmt.Errorf("invalid %s %q", key, value)
+	}
+	switch {
+	case name == "low" && perAddress:
+		alarmLowByAddress[adr] = limit
+	case name == "low":
+		alarmLow = limit
+	case perAddress:
+		alarmHighByAddress[adr] = limit
+	default:
+		alarmHigh = limit
+	}
+	return nil
+}
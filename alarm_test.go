@@ -0,0 +1,68 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+// alarmTest resets the alarm state of the given addresses
+func alarmTest(t *testing.T, adrs ...byte) {
+	t.Helper()
+	setAddresses(t, adrs...)
+	setVar(t, &alarmLow, math.Inf(-1))
+	setVar(t, &alarmHigh, math.Inf(1))
+	setVar(t, &alarmWindow, 1)
+	setVar(t, &alarmLowByAddress, map[byte]float64{})
+	setVar(t, &alarmHighByAddress, map[byte]float64{})
+	setVar(t, &alarmWindowByAddr, map[byte]int{})
+	setVar(t, &alarmSamples, [MAXNUMADR][]float64{})
+	setVar(t, &alarmActive, [MAXNUMADR]bool{})
+}
+
+func TestAlarmWindow(t *testing.T) {
+	alarmTest(t, 1)
+	for _, kv := range [][2]string{{"alarm.high", "30"}, {"alarm.window", "3"}} {
+		if err := setAlarmKey(kv[0], kv[1]); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// A single spike does not move the average of three values past 30
+	for _, v := range []string{"20", "20", "20", "45", "20", "20"} {
+		checkAlarm(0, v)
+		if alarmActive[0] {
+			t.Fatalf("alarm raised by the spike, samples %v", alarmSamples[0])
+		}
+	}
+	// A sustained excursion does, and the alarm clears once it ends
+	for i, v := range []string{"40", "40", "40", "20", "20", "20"} {
+		checkAlarm(0, v)
+		if want := i >= 1 && i < 4; alarmActive[0] != want {
+			t.Errorf("after %s (step %d) alarm %v, want %v, samples %v", v, i, alarmActive[0], want, alarmSamples[0])
+		}
+	}
+}
+
+func TestAlarmPerAddress(t *testing.T) {
+	alarmTest(t, 1, 2)
+	for _, kv := range [][2]string{{"alarm.low", "0"}, {"alarm.low.2", "-20"}, {"alarm.window.2", "1"}} {
+		if err := setAlarmKey(kv[0], kv[1]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	checkAlarm(0, "-5")
+	checkAlarm(1, "-5")
+	if !alarmActive[0] || alarmActive[1] {
+		t.Errorf("alarms %v, want address 1 only", alarmActive[:2])
+	}
+	checkAlarm(1, "100001") // status codes are ignored
+	if alarmActive[1] || len(alarmSamples[1]) != 1 {
+		t.Errorf("status code entered the window %v", alarmSamples[1])
+	}
+
+	for _, kv := range [][2]string{{"alarm.window", "0"}, {"alarm.high", "x"}, {"alarm.low.x", "1"}} {
+		if err := setAlarmKey(kv[0], kv[1]); err == nil {
+			t.Errorf("%s = %q accepted", kv[0], kv[1])
+		}
+	}
+}
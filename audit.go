@@ -0,0 +1,84 @@
+package main
+
+import (
+	"encoding/json"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+)
+
+// Audit log of channel status writes, one JSON line per entry
+var (
+	auditFile     string // "" = no audit log
+	auditCoalesce = false
+)
+
+// auditEntry is one or more identical consecutive status writes
+type auditEntry struct {
+	Channel int       `json:"channel"`
+	Status  string    `json:"status"`
+	Count   int       `json:"count"`
+	First   time.Time `json:"first"`
+	Last    time.Time `json:"last"`
+}
+
+var (
+	auditMu      sync.Mutex
+	auditPending = make(map[int]*auditEntry)
+)
+
+// auditStatus records a status write. With coalescing, identical writes to
+// a channel are collected into one entry that is written when the status
+// changes or the program exits.
+func auditStatus(idChannel int, status string, t time.Time) {
+	if auditFile == "" {
+		return
+	}
+	auditMu.Lock()
+	defer auditMu.Unlock()
+
+	if !auditCoalesce {
+		writeAudit(&auditEntry{Channel: idChannel, Status: status, Count: 1, First: t, Last: t})
+		return
+	}
+
+	if e, ok := auditPending[idChannel]; ok {
+		if e.Status == status {
+			e.Count++
+			e.Last = t
+			return
+		}
+		writeAudit(e)
+	}
+	auditPending[idChannel] = &auditEntry{Channel: idChannel, Status: status, Count: 1, First: t, Last: t}
+}
+
+// flushAudit writes all coalesced entries still pending
+func flushAudit() {
+	auditMu.Lock()
+	defer auditMu.Unlock()
+
+	for id, e := range auditPending {
+		writeAudit(e)
+		delete(auditPending, id)
+	}
+}
+
+func writeAudit(e *auditEntry) {
+	line, err := json.Marshal(e)
+	if err != nil {
+		slog.Error("audit entry encoding failed", "error", err)
+		return
+	}
+	f, err := os.OpenFile(auditFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		slog.Error("audit log open failed", "file", auditFile, "error", err)
+		return
+	}
+	defer f.Close()
+
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		slog.Error("audit log write failed", "file", auditFile, "error", err)
+	}
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// readAudit returns the entries of the audit log
+func readAudit(t *testing.T) []auditEntry {
+	t.Helper()
+	f, err := os.Open(auditFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	var entries []auditEntry
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var e auditEntry
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			t.Fatal(err)
+		}
+		entries = append(entries, e)
+	}
+	return entries
+}
+
+func TestAuditCoalesce(t *testing.T) {
+	setVar(t, &auditFile, filepath.Join(t.TempDir(), "audit.log"))
+	setVar(t, &auditCoalesce, true)
+	setVar(t, &auditPending, map[int]*auditEntry{})
+
+	t0 := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	const n = 7
+	for i := 0; i < n; i++ {
+		auditStatus(1, "normal", t0.Add(time.Duration(i)*time.Minute))
+	}
+	auditStatus(1, "100002", t0.Add(time.Hour))
+	flushAudit()
+
+	entries := readAudit(t)
+	if len(entries) != 2 {
+		t.Fatalf("%d audit entries, want 2: %+v", len(entries), entries)
+	}
+	e := entries[0]
+	if e.Status != "normal" || e.Count != n || !e.First.Equal(t0) || !e.Last.Equal(t0.Add((n-1)*time.Minute)) {
+		t.Errorf("coalesced entry %+v, want %d normal writes from %v", e, n, t0)
+	}
+	if e := entries[1]; e.Status != "100002" || e.Count != 1 {
+		t.Errorf("second entry %+v, want one 100002 write", e)
+	}
+}
+
+func TestAuditWithoutCoalesce(t *testing.T) {
+	setVar(t, &auditFile, filepath.Join(t.TempDir(), "audit.log"))
+	setVar(t, &auditCoalesce, false)
+
+	for i := 0; i < 3; i++ {
+		auditStatus(1, "normal", time.Now())
+	}
+	if entries := readAudit(t); len(entries) != 3 {
+		t.Errorf("%d audit entries, want one per write", len(entries))
+	}
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"strconv"
+	"strings"
+)
+
+// Row checksums. With checksumAlgorithm set, the hash of the configured
+// record fields is stored with each row so later changes can be detected.
+// The fields are joined with "|" in the configured order; the time is the
+// datetime string written to the row.
+var (
+	checksumAlgorithm = "" // "sha256" or "sha512", "" = no checksum
+	checksumFields    = []string{"serial", "type", "time", "value"}
+)
+
+var checksumHashes = map[string]func() hash.Hash{
+	"sha256": sha256.New,
+	"sha512": sha512.New,
+}
+
+// checksumField returns the value of a record field by name
+func checksumField(rec record, field string) (string, bool) {
+	switch field {
+	case "serial":
+		return rec.Serial, true
+	case "type":
+		return rec.Type, true
+	case "time":
+		return makeDatetime(rec.Time), true
+	case "value":
+		return rec.Value, true
+	case "unit":
+		return rec.Unit, true
+	case "index":
+		return strconv.Itoa(rec.Index), true
+	case "quality":
+		return rec.Quality, true
+	case "retries":
+		return strconv.Itoa(rec.Retries), true
+	case "raw":
+		return rec.Raw, true
+	case "tag":
+		return rec.Tag, true
+	case "source":
+		return rec.Source, true
+	case "device":
+		return rec.Device, true
+	}
+	return "", false
+}
+
+// parseChecksumFields parses the comma separated list of hashed fields
+func parseChecksumFields(s string) ([]string, error) {
+	var fields []string
+	for _, field := range strings.Split(s, ",") {
+		field = strings.TrimSpace(field)
+		if _, ok := checksumField(record{}, field); !ok {
+			return nil, fmt.Errorf("unknown checksum field %q", field)
+		}
+		fields = append(fields, field)
+	}
+	return fields, nil
+}
+
+// recordChecksum returns the hex encoded checksum of rec
+func recordChecksum(rec record) string {
+	values := make([]string, len(checksumFields))
+	for i, field := range checksumFields {
+		values[i], _ = checksumField(rec, field)
+	}
+	h := checksumHashes[checksumAlgorithm]()
+	h.Write([]byte(strings.Join(values, "|")))
+	return hex.EncodeToString(h.Sum(nil))
+}
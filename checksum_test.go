@@ -0,0 +1,55 @@
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"testing"
+	"time"
+)
+
+func TestStoredChecksum(t *testing.T) {
+	sock := testDB(t, channelDDL, unitDDL,
+		"CREATE TABLE data (id_channel integer, datetime text, value text, checksum varchar(128))")
+	provision(t, sock, "S1")
+	setVar(t, &storeChecksum, true)
+	setVar(t, &checksumAlgorithm, "sha256")
+	setVar(t, &checksumFields, checksumFields)
+
+	when := time.Date(2024, 3, 1, 12, 30, 0, 0, time.Local)
+	if status := writeToDB(record{Serial: "S1", Value: "20.5", Type: "temperature", Time: when}); status != 0 {
+		t.Fatalf("writeToDB = %d", status)
+	}
+
+	var datetime, value, stored string
+	if err := sock.QueryRow("SELECT datetime, value, checksum FROM data").Scan(&datetime, &value, &stored); err != nil {
+		t.Fatal(err)
+	}
+	sum := sha256.Sum256([]byte("S1|temperature|" + datetime + "|" + value))
+	if want := hex.EncodeToString(sum[:]); stored != want {
+		t.Errorf("stored checksum %s, recomputed %s", stored, want)
+	}
+}
+
+func TestChecksumFields(t *testing.T) {
+	setVar(t, &checksumAlgorithm, "sha512")
+	fields, err := parseChecksumFields("serial, tag ,value")
+	if err != nil {
+		t.Fatal(err)
+	}
+	setVar(t, &checksumFields, fields)
+
+	rec := record{Serial: "S1", Tag: "room 1", Value: "20.5", Type: "ignored"}
+	sum := sha512.Sum512([]byte("S1|room 1|20.5"))
+	if got := recordChecksum(rec); got != hex.EncodeToString(sum[:]) {
+		t.Errorf("checksum over serial, tag and value = %s", got)
+	}
+	rec.Value = "20.6"
+	if recordChecksum(rec) == hex.EncodeToString(sum[:]) {
+		t.Error("a changed value kept the checksum")
+	}
+
+	if _, err := parseChecksumFields("serial,colour"); err == nil {
+		t.Error("unknown checksum field accepted")
+	}
+}
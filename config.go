@@ -0,0 +1,381 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// configEntry describes one config file key. Plain entries point at the
+// variable they set, so the defaults printed by -configschema are the
+// initial values of the variables. Entries with a parser of their own use
+// set, and get to show their default.
+type configEntry struct {
+	key      string
+	desc     string
+	ptr      any      // *string, *bool, *int, *int64, *float64 or *time.Duration (seconds)
+	values   []string // allowed values of a string entry
+	typ      string   // type shown for entries with a parser of their own
+	set      func(key, value string) error
+	get      func() string
+	prefix   bool // key is a prefix followed by a name or address
+	positive bool // numbers must be greater than 0
+}
+
+// errConfigValue marks malformed numbers, which are ignored
+var errConfigValue = errors.New("invalid number")
+
+// configKeys lists every key loadConfig accepts
+var configKeys = []configEntry{
+	{key: "SerialDevice", ptr: &serialDeviceStr, desc: "serial device the bus is connected to (default /dev/ttyUSB0)"},
+	{key: "scanAddresses", typ: "list", desc: "comma separated device addresses, may span several lines"},
+	{key: "minScanDelaySeconds", ptr: &minScanDelaySeconds, desc: "minimum time between scan starts"},
+	{key: "numberOfScans", ptr: &numScans, desc: "scans before exiting, 0 = run continuously"},
+	{key: "scan.alignSeconds", ptr: &scanAlign, desc: "start scans on multiples of this interval instead of after minScanDelaySeconds, 0 = off"},
+	{key: "scan.alignOffsetSeconds", ptr: &scanAlignOffset, desc: "offset of the aligned slots from the full interval"},
+	{key: "quiet.start", typ: "string", desc: "HH:MM start of the daily quiet hours without scanning, \"\" = none",
+		set: func(key, value string) (err error) {
+			quietStart, err = parseQuietTime(key, value)
+			return err
+		}},
+	{key: "quiet.end", typ: "string", desc: "HH:MM end of the quiet hours, may be before quiet.start to span midnight, \"\" = none",
+		set: func(key, value string) (err error) {
+			quietEnd, err = parseQuietTime(key, value)
+			return err
+		}},
+	{key: "maxAddressesPerScan", ptr: &maxAddressesPerScan, desc: "addresses polled per scan, continuing round-robin, 0 = all"},
+	{key: "startup.firstScan", ptr: &firstScanMode, values: []string{"store", "skip", "flag"},
+		desc: "handling of the first scan results of each address, flag stores them with the startup quality"},
+
+	{key: "responseDelaySeconds", ptr: &responseDelayMax, desc: "maximum wait for a response after a command"},
+	{key: "adaptiveResponseDelay", ptr: &adaptiveDelay, desc: "derive the response wait from the observed latencies"},
+	{key: "retries.sn", ptr: &snRetrys, positive: true, desc: "tries of the serial number query"},
+	{key: "retries.measure", ptr: &measureRetrys, positive: true, desc: "tries of a measurement"},
+	{key: "minPayload.sn", ptr: &minPayloadSN, desc: "minimum serial number payload length, 0 = no minimum"},
+	{key: "minPayload.measure", ptr: &minPayloadMeasure, desc: "minimum measurement payload length, 0 = no minimum"},
+	{key: "serial.disconnectErrors", ptr: &disconnectErrors, positive: true, desc: "consecutive I/O errors before the port is reacquired"},
+	{key: "serial.altTerminator", typ: "int", desc: "byte ending frames besides ETX, e.g. 0x0d, \"\" = ETX only",
+		set: func(key, value string) error {
+			if value == "" {
+				altTerminator = -1
+				return nil
+			}
+			b, err := strconv.ParseUint(value, 0, 8)
+			if err != nil {
+				return fmt.Errorf("invalid %s %q", key, value)
+			}
+			altTerminator = int(b)
+			return nil
+		}},
+	{key: "serial.openDelaySeconds", ptr: &portOpenDelay, desc: "wait after opening the port before the first command"},
+	{key: "serial.openFailures", ptr: &openFailureLimit, positive: true, desc: "consecutive port open failures before the policy applies"},
+	{key: "serial.openFailurePolicy", ptr: &openFailurePolicy, values: []string{"retry", "degraded", "exit"},
+		desc: "action after serial.openFailures: keep retrying, report the port degraded, or exit"},
+	{key: "serial.devicePattern", ptr: &serialDevicePattern, desc: "glob searched for the device after a disconnect"},
+	{key: "diag.noiseSeconds", ptr: &noiseSample, desc: "idle bus listening before each scan, 0 = off"},
+
+	{key: "enabled.", prefix: true, typ: "bool", desc: "0 stops polling one address without removing it, reread on SIGHUP",
+		set: setEnabled},
+	{key: "measureCommand", typ: "string", desc: "measurement command sequence, separated by ;",
+		set: func(key, value string) error {
+			if measureDefault = splitCommands(value); len(measureDefault) == 0 {
+				return errors.New("empty measureCommand")
+			}
+			return nil
+		},
+		get: func() string { return strings.Join(measureDefault, "; ") }},
+	{key: "measureCommand.", prefix: true, typ: "string", desc: "measurement command sequence of one address",
+		set: func(key, value string) error {
+			adr, err := parseKeyAddress(key, "measureCommand.")
+			if err != nil {
+				return err
+			}
+			if measureByAddress[adr] = splitCommands(value); len(measureByAddress[adr]) == 0 {
+				return fmt.Errorf("empty %s", key)
+			}
+			return nil
+		}},
+	{key: "measureType", ptr: &measureTypeDefault, desc: "measurement type, selects the data table"},
+	{key: "measureType.", prefix: true, typ: "string", desc: "measurement type of one address",
+		set: func(key, value string) error {
+			adr, err := parseKeyAddress(key, "measureType.")
+			measureTypes[adr] = value
+			return err
+		}},
+	{key: "precision.command", typ: "string", desc: "high precision measurement command sequence, separated by ;, \"\" = none",
+		set: func(key, value string) error {
+			precisionCommand = splitCommands(value)
+			return nil
+		}},
+	{key: "precision.timeoutSeconds", ptr: &precisionTimeout, positive: true, desc: "response wait of the high precision measurement"},
+	{key: "precision.intervalSeconds", ptr: &precisionInterval, desc: "interval of the high precision measurement per address"},
+	{key: "precision.type", ptr: &precisionType, desc: "measurement type high precision values are stored under"},
+	{key: "vector.", prefix: true, typ: "enum", values: []string{"rows", "json"},
+		desc: "store the vector response of one address as indexed rows or a JSON array",
+		set: func(key, value string) error {
+			adr, err := parseKeyAddress(key, "vector.")
+			vectorModes[adr] = value
+			return err
+		}},
+	{key: "validate", typ: "string", desc: "condition a value must meet to be stored, e.g. value > -50 && value < 150",
+		set: func(key, value string) error {
+			v, err := parseValidator(value)
+			validateDefault = v
+			return err
+		}},
+	{key: "validate.", prefix: true, typ: "string", desc: "validator of one address",
+		set: func(key, value string) error {
+			adr, err := parseKeyAddress(key, "validate.")
+			if err != nil {
+				return err
+			}
+			validateByAddress[adr], err = parseValidator(value)
+			return err
+		}},
+	{key: "alarm.low", typ: "float", desc: "alarm below this window average, unset = no limit", set: setAlarmKey},
+	{key: "alarm.high", typ: "float", desc: "alarm above this window average, unset = no limit", set: setAlarmKey},
+	{key: "alarm.window", typ: "int", desc: "values averaged for the alarm check", set: setAlarmKey,
+		get: func() string { return strconv.Itoa(alarmWindow) }},
+	{key: "alarm.low.", prefix: true, typ: "float", desc: "low alarm limit of one address", set: setAlarmKey},
+	{key: "alarm.high.", prefix: true, typ: "float", desc: "high alarm limit of one address", set: setAlarmKey},
+	{key: "alarm.window.", prefix: true, typ: "int", desc: "alarm window of one address", set: setAlarmKey},
+	{key: "unitCommand", ptr: &unitCommand, desc: "command reading the unit once per device, \"\" = none"},
+	{key: "tagCommand", ptr: &tagCommand, desc: "command reading the location tag once per device, \"\" = none"},
+	{key: "settingsCommand", ptr: &settingsCommand, desc: "command reading the averaging/sample rate settings once per device, \"\" = none"},
+	{key: "runtimeCommand", ptr: &runtimeCommand, desc: "command reading the operating hours counter of a device, \"\" = none"},
+	{key: "runtimeIntervalSeconds", ptr: &runtimeInterval, desc: "interval of the operating hours query"},
+	{key: "unit.source", ptr: &unitSource, values: []string{"", "C", "F", "K"},
+		desc: "temperature unit assumed for devices reporting none"},
+	{key: "unit.target", ptr: &unitTarget, values: []string{"", "C", "F", "K"},
+		desc: "temperature unit values are converted to before storing, \"\" = no conversion"},
+	{key: "reset.command", ptr: &resetCommand, desc: "device reset command, \"\" = no resets"},
+	{key: "reset.afterFailedScans", ptr: &resetAfterScans, positive: true, desc: "consecutive failed scans before a reset"},
+	{key: "reset.waitSeconds", ptr: &resetWait, desc: "time a reset device is left alone"},
+	{key: "reset.addresses", typ: "list", desc: "addresses supporting the reset command, \"\" = all",
+		set: func(key, value string) error {
+			resetAddresses = parseAddressSet(value)
+			return nil
+		}},
+	{key: "errorCode.", prefix: true, typ: "string", desc: "description of a device status code, declaring codes besides 100001, 100002 and 100003",
+		set: func(key, value string) error {
+			addErrorCode(strings.TrimPrefix(key, "errorCode."), value)
+			return nil
+		}},
+
+	{key: "store", ptr: &storeBackend, values: []string{"db", "http"}, desc: "where measurements are stored: the database or an HTTP ingestion endpoint"},
+	{key: "http.url", ptr: &httpURL, desc: "URL measurements are POSTed to as JSON with store = http"},
+	{key: "http.authHeader", ptr: &httpAuth, desc: "Authorization header of the POST requests, \"\" = none"},
+	{key: "http.timeoutSeconds", ptr: &httpTimeout, positive: true, desc: "timeout of one POST request"},
+	{key: "http.retries", ptr: &httpRetries, desc: "retries of a POST failing with a network error or 5xx status"},
+	{key: "http.retryDelaySeconds", ptr: &httpRetryDelay, desc: "wait before the first POST retry, doubled on each further retry"},
+	{key: "db.driver", ptr: &dbDriver, values: []string{"postgres", "mysql"}, desc: "database driver"},
+	{key: "db.host", ptr: &db.Host, desc: "database host"},
+	{key: "db.user", ptr: &db.User, desc: "database user"},
+	{key: "db.passwd", ptr: &db.Passwd, desc: "database password"},
+	{key: "db.name", ptr: &db.Name, desc: "database name"},
+	{key: "db.verifySchema", ptr: &verifySchemaAtStart, desc: "check the database schema at startup"},
+	{key: "db.writerate", ptr: &dbWriteRate, desc: "maximum database writes per second, 0 = unlimited"},
+	{key: "db.statusOnChange", ptr: &statusOnChange, desc: "only update a channel status when it changes"},
+	{key: "db.datetimeFormat", ptr: &datetimeFormat, desc: "default, iso8601, iso8601utc or a Go time layout"},
+	{key: "db.storeQuality", ptr: &storeQuality, desc: "store first try and retry count of each measurement"},
+	{key: "db.storeErrorCodes", ptr: &storeErrorCodes, desc: "store status codes, their descriptions and the raw response in their own columns"},
+	{key: "db.valueType", ptr: &valueType, values: []string{"text", "numeric"}, desc: "type of the value column"},
+	{key: "db.storeRawValue", ptr: &storeRawValue, desc: "store the value before unit conversion"},
+	{key: "db.storeDevice", ptr: &storeDevice, desc: "store the serial device path each value was read on"},
+	{key: "db.storeSource", ptr: &storeSource, desc: "store the source tag of each row"},
+	{key: "db.source", ptr: &sourceTag, values: []string{"live", "import", "buffer"}, desc: "source tag of the scanned rows, imported rows are tagged import and replayed rows buffer"},
+	{key: "db.bufferSize", ptr: &bufferSize, desc: "records kept while the store is unreachable and replayed with source tag buffer, 0 = none"},
+	{key: "import.dir", ptr: &importDir, desc: "directory of serial,type,time,value CSV files stored before each scan with source tag import, \"\" = none"},
+	{key: "db.checksum", ptr: &checksumAlgorithm, values: []string{"", "sha256", "sha512"},
+		desc: "hash stored with each row over db.checksumFields, \"\" = none"},
+	{key: "db.checksumFields", typ: "list", desc: "hashed record fields: serial, type, time, value, unit, index, quality, retries, raw, tag, source, device",
+		set: func(key, value string) (err error) {
+			checksumFields, err = parseChecksumFields(value)
+			return err
+		},
+		get: func() string { return strings.Join(checksumFields, ",") }},
+	{key: "db.checkUnits", ptr: &checkUnitsAtStart, desc: "report addresses without a unit in the database"},
+	{key: "address.serial.", prefix: true, typ: "string", desc: "serial number expected on one address, checked at startup with db.checkUnits",
+		set: func(key, value string) error {
+			adr, err := parseKeyAddress(key, "address.serial.")
+			addressSerials[adr] = value
+			return err
+		}},
+	{key: "db.lookupRetries", ptr: &lookupRetries, desc: "retries of a channel lookup failing transiently"},
+	{key: "schema.", prefix: true, typ: "string", desc: "database name of a logical table or column, e.g. schema.data.value",
+		set: func(key, value string) error {
+			return setSchemaName(strings.TrimPrefix(key, "schema."), value)
+		}},
+
+	{key: "metrics.listen", ptr: &metricsListen, desc: "address of the metrics and status endpoint, \"\" = off"},
+	{key: "metrics.buildInfo", ptr: &exposeBuildInfo, desc: "export sensor_build_info on the metrics endpoint"},
+	{key: "metrics.lastError", ptr: &exposeLastError, desc: "show the last error of each address in /status and /metrics"},
+	{key: "json.field.", prefix: true, typ: "string", desc: "name of a field in the JSON status output",
+		set: func(key, value string) error {
+			jsonFields[strings.TrimPrefix(key, "json.field.")] = value
+			return nil
+		}},
+	{key: "extremes.intervalSeconds", ptr: &extremesInterval, desc: "extremes reset interval, 0 = since start"},
+	{key: "counters.resetTime", typ: "string", desc: "daily HH:MM reset of the message counters, \"\" = never",
+		set: func(key, value string) error { return parseResetTime(value) }},
+	{key: "heartbeat.interval", ptr: &heartbeatInterval, desc: "interval of the alive log record, 0 = off"},
+	{key: "heartbeat.file", ptr: &heartbeatFile, desc: "file touched on every heartbeat"},
+	{key: "systemd.notify", ptr: &systemdNotify, desc: "send systemd readiness and watchdog notifications"},
+	{key: "shutdown.finalscan", ptr: &finalScan, desc: "scan once more on graceful shutdown, not in the quiet hours"},
+	{key: "shutdown.finalscanSeconds", ptr: &finalScanBudget, desc: "time budget of the final scan"},
+	{key: "audit.file", ptr: &auditFile, desc: "status audit log file, \"\" = off"},
+	{key: "audit.coalesce", ptr: &auditCoalesce, desc: "coalesce identical consecutive audit entries"},
+
+	{key: "log.file", ptr: &logFile, desc: "log file written in addition to stderr"},
+	{key: "log.fileLevel", typ: "enum", values: []string{"debug", "info", "warn", "error"}, desc: "level of the log file",
+		set: func(key, value string) error {
+			logFileLevel = parseLogLevel(value)
+			return nil
+		},
+		get: func() string { return strings.ToLower(logFileLevel.String()) }},
+	{key: "log.throttleSeconds", ptr: &logThrottle, desc: "window repeated warnings and errors are collapsed in, 0 = off"},
+}
+
+// lookupConfigKey returns the entry of a config file key, nil if unknown
+func lookupConfigKey(key string) *configEntry {
+	for i := range configKeys {
+		k := &configKeys[i]
+		if k.key == key || (k.prefix && strings.HasPrefix(key, k.key)) {
+			return k
+		}
+	}
+	return nil
+}
+
+// apply parses value into the entry's variable. Malformed or negative
+// numbers leave the variable unchanged and return errConfigValue.
+func (k *configEntry) apply(key, value string) error {
+	if k.values != nil && !slices.Contains(k.values, value) {
+		return fmt.Errorf("unsupported %s %q", key, value)
+	}
+	if k.set != nil {
+		return k.set(key, value)
+	}
+
+	if p, ok := k.ptr.(*string); ok {
+		*p = value
+		return nil
+	}
+	if p, ok := k.ptr.(*bool); ok {
+		*p = value == "1"
+		return nil
+	}
+
+	num, err := strconv.ParseFloat(value, 64)
+	if err != nil || num < 0 || (k.positive && num == 0) {
+		return fmt.Errorf("%w %s %q", errConfigValue, key, value)
+	}
+	switch p := k.ptr.(type) {
+	case *int:
+		if num != float64(int(num)) {
+			return fmt.Errorf("%w %s %q", errConfigValue, key, value)
+		}
+		*p = int(num)
+	case *int64:
+		if num != float64(int64(num)) {
+			return fmt.Errorf("%w %s %q", errConfigValue, key, value)
+		}
+		*p = int64(num)
+	case *float64:
+		*p = num
+	case *time.Duration:
+		*p = time.Duration(num * float64(time.Second))
+	}
+	return nil
+}
+
+// typeName returns the type shown by -configschema
+func (k *configEntry) typeName() string {
+	if k.typ != "" {
+		return k.typ
+	}
+	if k.values != nil {
+		return "enum"
+	}
+	switch k.ptr.(type) {
+	case *bool:
+		return "bool"
+	case *int, *int64:
+		return "int"
+	case *float64:
+		return "float"
+	case *time.Duration:
+		return "seconds"
+	}
+	return "string"
+}
+
+// defaultValue returns the value the entry has without a config file
+func (k *configEntry) defaultValue() string {
+	if k.get != nil {
+		return k.get()
+	}
+	switch p := k.ptr.(type) {
+	case *string:
+		return *p
+	case *bool:
+		if *p {
+			return "1"
+		}
+		return "0"
+	case *int:
+		return strconv.Itoa(*p)
+	case *int64:
+		return strconv.FormatInt(*p, 10)
+	case *float64:
+		return strconv.FormatFloat(*p, 'g', -1, 64)
+	case *time.Duration:
+		return strconv.FormatFloat(p.Seconds(), 'g', -1, 64)
+	}
+	return ""
+}
+
+// printConfigSchema writes every config key with type, default and
+// description as tab separated lines
+func printConfigSchema(w io.Writer) {
+	fmt.Fprintln(w, "key\ttype\tdefault\tdescription")
+	for i := range configKeys {
+		k := &configKeys[i]
+		key := k.key
+		if k.prefix {
+			key += "<name>"
+		}
+		desc := k.desc
+		if k.values != nil {
+			desc += " (" + strings.Join(k.values, ", ") + ")"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%q\t%s\n", key, k.typeName(), k.defaultValue(), desc)
+	}
+}
+
+// parseKeyAddress returns the address a per-address key ends with
+func parseKeyAddress(key, prefix string) (byte, error) {
+	adr, err := strconv.ParseUint(strings.TrimPrefix(key, prefix), 10, 8)
+	if err != nil {
+		return 0, fmt.Errorf("invalid address in %q", key)
+	}
+	return byte(adr), nil
+}
+
+// deriveConfig sets the switches that follow from other config keys
+func deriveConfig() {
+	storeUnit = unitCommand != "" || unitTarget != ""
+	storeTag = tagCommand != ""
+	storeSettings = settingsCommand != ""
+	storeRuntime = runtimeCommand != ""
+	storeChecksum = checksumAlgorithm != ""
+	storeQualityFlag = firstScanMode == "flag"
+	storeIndex = false
+	for _, mode := range vectorModes {
+		storeIndex = storeIndex || mode == "rows"
+	}
+}
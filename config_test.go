@@ -0,0 +1,93 @@
+package main
+
+import (
+	"bytes"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// schemaDefaults returns the defaults printed by -configschema by key
+func schemaDefaults(t *testing.T) map[string]string {
+	t.Helper()
+	var buf bytes.Buffer
+	printConfigSchema(&buf)
+	lines := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+	if lines[0] != "key\ttype\tdefault\tdescription" {
+		t.Fatalf("header %q", lines[0])
+	}
+	defaults := make(map[string]string)
+	for _, line := range lines[1:] {
+		fields := strings.Split(line, "\t")
+		if len(fields) != 4 {
+			t.Fatalf("line %q has %d fields, want 4", line, len(fields))
+		}
+		if _, dup := defaults[fields[0]]; dup {
+			t.Errorf("key %s listed twice", fields[0])
+		}
+		def, err := strconv.Unquote(fields[2])
+		if err != nil {
+			t.Fatalf("default of %s: %v", fields[0], err)
+		}
+		defaults[fields[0]] = def
+	}
+	return defaults
+}
+
+func TestConfigSchemaListsEveryKey(t *testing.T) {
+	defaults := schemaDefaults(t)
+	if len(defaults) != len(configKeys) {
+		t.Errorf("schema lists %d keys, want %d", len(defaults), len(configKeys))
+	}
+	for i := range configKeys {
+		k := &configKeys[i]
+		key := k.key
+		if k.prefix {
+			key += "<name>"
+		}
+		def, ok := defaults[key]
+		if !ok {
+			t.Errorf("key %s missing from the schema", key)
+			continue
+		}
+		if def != k.defaultValue() {
+			t.Errorf("default of %s = %q, want %q", key, def, k.defaultValue())
+		}
+	}
+
+	for key, want := range map[string]string{
+		"minScanDelaySeconds": "60",
+		"numberOfScans":       "1",
+		"retries.sn":          strconv.Itoa(maxRetrys),
+		"startup.firstScan":   "store",
+		"log.fileLevel":       "info",
+	} {
+		if defaults[key] != want {
+			t.Errorf("default of %s = %q, want %q", key, defaults[key], want)
+		}
+	}
+}
+
+// Setting a key to its printed default must not change the variable
+func TestConfigSchemaDefaultsRoundTrip(t *testing.T) {
+	for i := range configKeys {
+		k := &configKeys[i]
+		if k.ptr == nil {
+			continue
+		}
+		v := reflect.ValueOf(k.ptr).Elem()
+		saved := reflect.New(v.Type()).Elem()
+		saved.Set(v)
+		t.Cleanup(func() { v.Set(saved) })
+
+		def := k.defaultValue()
+		if err := k.apply(k.key, def); err != nil {
+			t.Errorf("%s = %q: %v", k.key, def, err)
+			continue
+		}
+		if !v.Equal(saved) {
+			t.Errorf("%s = %q changed the value from %v to %v", k.key, def, saved, v)
+		}
+	}
+}
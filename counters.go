@@ -0,0 +1,78 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"time"
+)
+
+// Daily counter reset
+var (
+	counterReset     = false
+	counterResetHour int
+	counterResetMin  int
+	nextCounterReset time.Time
+	lastCounterReset time.Time
+)
+
+// Counts accumulated before the last reset, so the metrics keep reporting
+// cumulative counters
+var (
+	sentBase     [MAXNUMADR]int64
+	receivedBase [MAXNUMADR]int64
+	nakBase      [MAXNUMADR]int64
+)
+
+// parseResetTime parses the "HH:MM" time of the daily counter reset, ""
+// turns the reset off
+func parseResetTime(s string) error {
+	if s == "" {
+		counterReset = false
+		return nil
+	}
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		return fmt.Errorf("invalid counters.resetTime %q", s)
+	}
+	counterReset = true
+	counterResetHour, counterResetMin = t.Hour(), t.Minute()
+	return nil
+}
+
+// nextResetAfter returns the first reset time after now
+func nextResetAfter(now time.Time) time.Time {
+	next := time.Date(now.Year(), now.Month(), now.Day(), counterResetHour, counterResetMin, 0, 0, now.Location())
+	if !next.After(now) {
+		next = next.AddDate(0, 0, 1)
+	}
+	return next
+}
+
+// checkCounterReset logs the message counters of the past day and zeroes
+// them once the configured reset time has passed.
+func checkCounterReset(now time.Time) {
+	if !counterReset {
+		return
+	}
+	if nextCounterReset.IsZero() {
+		nextCounterReset = nextResetAfter(now)
+		return
+	}
+	if now.Before(nextCounterReset) {
+		return
+	}
+
+	for i := 0; i < numAdresses; i++ {
+		slog.Info("daily counters", "address", scanAddress[i], "serial", serNoStr[i],
+			"sent", msgSent[i], "received", msgReceived[i], "NAK", msgNAK[i])
+		sentBase[i] += msgSent[i]
+		receivedBase[i] += msgReceived[i]
+		nakBase[i] += msgNAK[i]
+		msgSent[i] = 0
+		msgReceived[i] = 0
+		msgNAK[i] = 0
+	}
+	lastCounterReset = now
+	nextCounterReset = nextResetAfter(now)
+	slog.Info("counters reset", "next", nextCounterReset)
+}
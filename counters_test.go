@@ -0,0 +1,62 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCounterReset(t *testing.T) {
+	setAddresses(t, 1, 2)
+	setVar(t, &counterReset, false)
+	setVar(t, &nextCounterReset, time.Time{})
+	setVar(t, &lastCounterReset, time.Time{})
+	setVar(t, &msgSent, [MAXNUMADR]int64{10, 20})
+	setVar(t, &msgReceived, [MAXNUMADR]int64{9, 18})
+	setVar(t, &msgNAK, [MAXNUMADR]int64{1, 2})
+	setVar(t, &sentBase, [MAXNUMADR]int64{})
+	setVar(t, &receivedBase, [MAXNUMADR]int64{})
+	setVar(t, &nakBase, [MAXNUMADR]int64{})
+	if err := parseResetTime("03:00"); err != nil {
+		t.Fatal(err)
+	}
+
+	day := time.Date(2024, 3, 1, 0, 0, 0, 0, time.Local)
+	checkCounterReset(day.Add(2*time.Hour + 59*time.Minute))
+	checkCounterReset(day.Add(3*time.Hour - time.Second))
+	if msgSent[0] != 10 || !lastCounterReset.IsZero() {
+		t.Fatalf("counters reset before the reset time")
+	}
+
+	resetAt := day.Add(3*time.Hour + 30*time.Second)
+	checkCounterReset(resetAt)
+	if msgSent != [MAXNUMADR]int64{} || msgReceived != [MAXNUMADR]int64{} || msgNAK != [MAXNUMADR]int64{} {
+		t.Errorf("counters not zeroed: sent %v", msgSent[:2])
+	}
+	if sentBase[1] != 20 || receivedBase[1] != 18 || nakBase[1] != 2 {
+		t.Errorf("cumulative base %d/%d/%d, want 20/18/2", sentBase[1], receivedBase[1], nakBase[1])
+	}
+	if !lastCounterReset.Equal(resetAt) || !nextCounterReset.Equal(day.AddDate(0, 0, 1).Add(3*time.Hour)) {
+		t.Errorf("reset at %v, next %v; want %v and 03:00 the next day", lastCounterReset, nextCounterReset, resetAt)
+	}
+
+	msgSent[0] = 5
+	checkCounterReset(resetAt.Add(time.Hour))
+	if msgSent[0] != 5 {
+		t.Error("counters reset twice on one day")
+	}
+}
+
+func TestCounterResetEmpty(t *testing.T) {
+	setVar(t, &counterReset, true)
+	setVar(t, &counterResetHour, counterResetHour)
+	setVar(t, &counterResetMin, counterResetMin)
+	if err := loadTestConfig(t, `counters.resetTime = ""`, `scanAddresses = "1"`); err != nil {
+		t.Fatalf("loadConfig with an empty counters.resetTime: %v", err)
+	}
+	if counterReset {
+		t.Error("counter reset still on")
+	}
+	if err := parseResetTime("3 o'clock"); err == nil {
+		t.Error("invalid reset time accepted")
+	}
+}
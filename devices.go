@@ -0,0 +1,133 @@
+package main
+
+import (
+	"log/slog"
+	"strings"
+	"time"
+)
+
+// Device identity per address
+var (
+	knownSerial    [MAXNUMADR]string // serial number seen in the last scan
+	previousSerial [MAXNUMADR]string // serial number before the last change
+	serialChanges  [MAXNUMADR]int64
+	collisions     [MAXNUMADR]int64
+)
+
+// checkSerial compares the serial number read from the address at index
+// idx with the one seen before. A single change is a device replacement;
+// flipping back to the previous serial points at two devices sharing the
+// address.
+func checkSerial(idx int, serial string) {
+	if serial == "" || serial == knownSerial[idx] {
+		return
+	}
+	adr := scanAddress[idx]
+
+	switch {
+	case knownSerial[idx] == "":
+		slog.Debug("device found", "address", adr, "serial", serial)
+	case serial == previousSerial[idx]:
+		collisions[idx]++
+		slog.Warn("serial number alternates, possible address collision", "address", adr,
+			"serial", serial, "previous", knownSerial[idx], "collisions", collisions[idx])
+	default:
+		slog.Info("device replaced", "address", adr, "serial", serial, "previous", knownSerial[idx])
+	}
+
+	if knownSerial[idx] != "" {
+		serialChanges[idx]++
+		previousSerial[idx] = knownSerial[idx]
+	}
+	knownSerial[idx] = serial
+}
+
+// Device metadata queries, each sent once per device and cached until its
+// serial number changes. Devices not supporting a query are tolerated and
+// cached with an empty value.
+var (
+	unitCommand string // "" = no unit query
+	storeUnit   = false
+	deviceUnit  [MAXNUMADR]string
+	unitSerial  [MAXNUMADR]string // serial number the cached unit belongs to
+
+	tagCommand string // location tag, the user programmed label of a device
+	storeTag   = false
+	deviceTag  [MAXNUMADR]string
+	tagSerial  [MAXNUMADR]string
+
+	settingsCommand string // sample rate/averaging configuration
+	storeSettings   = false
+	deviceSettings  [MAXNUMADR]string
+	settingsSerial  [MAXNUMADR]string
+)
+
+// queryUnit reads the measurement unit of the address at index idx
+func queryUnit(idx int) {
+	queryDevice(idx, "unit", unitCommand, &deviceUnit, &unitSerial)
+}
+
+// queryTag reads the location tag of the address at index idx
+func queryTag(idx int) {
+	queryDevice(idx, "tag", tagCommand, &deviceTag, &tagSerial)
+}
+
+// querySettings reads the averaging and sample rate settings of the address
+// at index idx
+func querySettings(idx int) {
+	queryDevice(idx, "settings", settingsCommand, &deviceSettings, &settingsSerial)
+}
+
+// queryDevice sends command to the address at index idx and caches the
+// response in values unless it is already known for the current device. A
+// NAK caches the value as unsupported, transport errors are retried on the
+// next scan.
+func queryDevice(idx int, name, command string, values, serials *[MAXNUMADR]string) {
+	serial := serNoStr[idx]
+	if command == "" || serial == "" || serial == serials[idx] {
+		return
+	}
+
+	var resp string
+	status, err := getValue(&resp, command, scanAddress[idx])
+	switch {
+	case err == nil && status == NAK:
+		slog.Debug(name+" not supported", "address", scanAddress[idx])
+		resp = ""
+	case err != nil || status != ACK:
+		slog.Debug(name+" query failed", "address", scanAddress[idx], "status", status, "error", err)
+		values[idx] = ""
+		return
+	}
+	values[idx] = strings.TrimSpace(resp)
+	serials[idx] = serial
+	slog.Debug("device "+name, "address", scanAddress[idx], "serial", serial, name, values[idx])
+}
+
+// Runtime counter query, the operating hours of a device. The counter
+// changes slowly, so it is read at the inventory cadence and whenever the
+// device is replaced.
+var (
+	runtimeCommand  string // "" = no runtime query
+	runtimeInterval = 24 * time.Hour
+	storeRuntime    = false
+	deviceRuntime   [MAXNUMADR]string
+	runtimeSerial   [MAXNUMADR]string
+	runtimeRead     [MAXNUMADR]time.Time
+)
+
+// queryRuntime reads the runtime counter of the address at index idx when
+// it is due. Devices without the counter are tolerated and cached with an
+// empty value.
+func queryRuntime(idx int) {
+	serial := serNoStr[idx]
+	if runtimeCommand == "" || serial == "" {
+		return
+	}
+	if serial == runtimeSerial[idx] && time.Since(runtimeRead[idx]) < runtimeInterval {
+		return
+	}
+	runtimeSerial[idx] = ""
+	queryDevice(idx, "runtime", runtimeCommand, &deviceRuntime, &runtimeSerial)
+	runtimeRead[idx] = time.Now()
+}
@@ -0,0 +1,232 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSerialCollision(t *testing.T) {
+	scanTest(t, &fakePort{}, 4)
+	log := captureLog(t)
+
+	for _, serial := range []string{"A", "A", "B", "A", "B"} {
+		checkSerial(0, serial)
+	}
+	if collisions[0] != 2 || serialChanges[0] != 3 {
+		t.Errorf("%d collisions and %d changes, want 2 and 3", collisions[0], serialChanges[0])
+	}
+	if n := strings.Count(log.String(), "possible address collision"); n != 2 {
+		t.Errorf("%d collision warnings, want 2:\n%s", n, log)
+	}
+}
+
+func TestSerialReplacement(t *testing.T) {
+	scanTest(t, &fakePort{}, 4)
+	log := captureLog(t)
+
+	for _, serial := range []string{"A", "B", "B", "C"} {
+		checkSerial(0, serial)
+	}
+	if collisions[0] != 0 || serialChanges[0] != 2 {
+		t.Errorf("%d collisions and %d changes, want 0 and 2", collisions[0], serialChanges[0])
+	}
+	if out := log.String(); strings.Contains(out, "collision") || strings.Count(out, "device replaced") != 2 {
+		t.Errorf("log %q, want two replacements and no collision", out)
+	}
+}
+
+func TestUnitQueriedOncePerDevice(t *testing.T) {
+	serial := "S1"
+	port := &fakePort{reply: func(adr byte, cmd string) []byte {
+		switch cmd {
+		case "SN ?":
+			return frame(ACK, serial)
+		case "UNIT ?":
+			return frame(ACK, map[string]string{"S1": "degC", "S2": "F"}[serial])
+		case "MEA CH 1 ?":
+			return frame(ACK, "21.5")
+		}
+		return nil
+	}}
+	stored := scanTest(t, port, 1)
+	setVar(t, &unitCommand, "UNIT ?")
+
+	countUnit := func() int {
+		n := 0
+		for _, c := range port.commands() {
+			if c.cmd == "UNIT ?" {
+				n++
+			}
+		}
+		return n
+	}
+
+	scan()
+	scan()
+	if n := countUnit(); n != 1 {
+		t.Errorf("unit queried %d times in 2 scans of one device, want once", n)
+	}
+	serial = "S2"
+	scan()
+	if n := countUnit(); n != 2 {
+		t.Errorf("unit queried %d times after the device changed, want twice", n)
+	}
+
+	var units []string
+	for _, rec := range stored.records() {
+		units = append(units, rec.Serial+" "+rec.Unit)
+	}
+	if want := []string{"S1 degC", "S1 degC", "S2 F"}; !slices.Equal(units, want) {
+		t.Errorf("stored units %q, want %q", units, want)
+	}
+}
+
+func TestTagAttachedToRows(t *testing.T) {
+	port := &fakePort{reply: func(adr byte, cmd string) []byte {
+		switch cmd {
+		case "SN ?":
+			return frame(ACK, fmt.Sprintf("S%d", adr))
+		case "TAG ?":
+			return frame(ACK, fmt.Sprintf(" room %d ", adr))
+		case "MEA CH 1 ?":
+			return frame(ACK, "20")
+		}
+		return nil
+	}}
+	stored := scanTest(t, port, 1, 2)
+	setVar(t, &tagCommand, "TAG ?")
+	setVar(t, &storeTag, true)
+
+	scan()
+	recs := stored.records()
+	if len(recs) != 2 || recs[0].Tag != "room 1" || recs[1].Tag != "room 2" {
+		t.Fatalf("stored %+v, want the tags room 1 and room 2", recs)
+	}
+
+	sock := testDB(t, channelDDL, unitDDL,
+		"CREATE TABLE data (id_channel integer, datetime timestamp, value real, tag varchar(32))")
+	provision(t, sock, "S1", "S2")
+	for _, rec := range recs {
+		if status := writeToDB(rec); status != 0 {
+			t.Fatalf("writeToDB = %d", status)
+		}
+	}
+	if tags := dataColumn(t, sock, "tag"); !slices.Equal(tags, []string{"room 1", "room 2"}) {
+		t.Errorf("stored tags %q, want room 1 and room 2", tags)
+	}
+}
+
+func TestSettingsAttachedToRows(t *testing.T) {
+	serial, settings := "S1", "avg=4 rate=10"
+	port := &fakePort{reply: func(adr byte, cmd string) []byte {
+		switch cmd {
+		case "SN ?":
+			return frame(ACK, serial)
+		case "SET ?":
+			return frame(ACK, settings)
+		case "MEA CH 1 ?":
+			return frame(ACK, "20")
+		}
+		return nil
+	}}
+	stored := scanTest(t, port, 1)
+	setVar(t, &settingsCommand, "SET ?")
+	setVar(t, &storeSettings, true)
+	sock := testDB(t, channelDDL, unitDDL,
+		"CREATE TABLE data (id_channel integer, datetime timestamp, value real, settings varchar(32))")
+	provision(t, sock, "S1", "S2")
+
+	scan()
+	settings = "avg=1 rate=1" // cached until the device is replaced
+	scan()
+	serial = "S2"
+	scan()
+
+	for _, rec := range stored.records() {
+		if status := writeToDB(rec); status != 0 {
+			t.Fatalf("writeToDB = %d", status)
+		}
+	}
+	want := []string{"avg=4 rate=10", "avg=4 rate=10", "avg=1 rate=1"}
+	if got := dataColumn(t, sock, "settings"); !slices.Equal(got, want) {
+		t.Errorf("stored settings %q, want %q", got, want)
+	}
+}
+
+func TestRuntimeReadAtItsCadence(t *testing.T) {
+	port := &fakePort{reply: answer(map[string]string{"HOURS ?": "1234"})}
+	scanTest(t, port, 1)
+	usePort(t, port)
+	setVar(t, &runtimeCommand, "HOURS ?")
+	setVar(t, &runtimeInterval, time.Hour)
+	serNoStr[0] = "S1"
+
+	queries := func() int {
+		n := 0
+		for _, c := range port.commands() {
+			if c.cmd == "HOURS ?" {
+				n++
+			}
+		}
+		return n
+	}
+
+	for i := 0; i < 3; i++ {
+		queryRuntime(0)
+	}
+	if n := queries(); n != 1 || deviceRuntime[0] != "1234" {
+		t.Errorf("%d queries within the interval, runtime %q; want 1 and 1234", n, deviceRuntime[0])
+	}
+	runtimeRead[0] = runtimeRead[0].Add(-time.Hour)
+	queryRuntime(0)
+	if n := queries(); n != 2 {
+		t.Errorf("%d queries after the interval, want 2", n)
+	}
+	serNoStr[0] = "S2" // a replaced device is read right away
+	queryRuntime(0)
+	if n := queries(); n != 3 {
+		t.Errorf("%d queries after the device was replaced, want 3", n)
+	}
+}
+
+func TestDeviceQueryRetriedAfterTransportError(t *testing.T) {
+	answers := 0
+	port := &fakePort{reply: func(adr byte, cmd string) []byte {
+		switch cmd {
+		case "MODEL ?":
+			if answers++; answers == 1 {
+				return nil // timeout
+			}
+			return frame(ACK, "TX-200")
+		case "UNIT ?":
+			return frame(NAK, "")
+		}
+		return nil
+	}}
+	scanTest(t, port, 1)
+	usePort(t, port)
+	serNoStr[0] = "S1"
+	count := func(cmd string) int {
+		n := 0
+		for _, c := range port.commands() {
+			if c.cmd == cmd {
+				n++
+			}
+		}
+		return n
+	}
+
+	for i := 0; i < 3; i++ {
+		queryDevice(0, "tag", "MODEL ?", &deviceTag, &tagSerial)
+		queryDevice(0, "unit", "UNIT ?", &deviceUnit, &unitSerial)
+	}
+	if n := count("MODEL ?"); n != 2 || deviceTag[0] != "TX-200" {
+		t.Errorf("queried %d times, cached %q; want a retry after the timeout and TX-200", n, deviceTag[0])
+	}
+	if n := count("UNIT ?"); n != 1 || deviceUnit[0] != "" {
+		t.Errorf("unsupported query sent %d times, cached %q; want once and empty", n, deviceUnit[0])
+	}
+}
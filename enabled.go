@@ -0,0 +1,88 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+)
+
+// disabledAddresses are kept in the config and the metrics but not polled,
+// set with enabled.<adr> = "0" entries. SIGHUP rereads them.
+var disabledAddresses = make(map[byte]bool)
+
+// addressEnabled reports whether the address at index idx is polled
+func addressEnabled(idx int) bool {
+	return !disabledAddresses[scanAddress[idx]]
+}
+
+// enabledOnly drops the disabled addresses from a scan batch
+func enabledOnly(batch []int) []int {
+	enabled := batch[:0:0]
+	for _, idx := range batch {
+		if addressEnabled(idx) {
+			enabled = append(enabled, idx)
+		}
+	}
+	return enabled
+}
+
+// setEnabled applies an "enabled.<adr>" config entry
+func setEnabled(key, value string) error {
+	adr, err := parseKeyAddress(key, "enabled.")
+	if err != nil {
+		return err
+	}
+	switch value {
+	case "1":
+		delete(disabledAddresses, adr)
+	case "0":
+		disabledAddresses[adr] = true
+	default:
+		return fmt.Errorf("invalid %s %q", key, value)
+	}
+	return nil
+}
+
+// reloadEnabled rereads the enabled flags from the config file. It waits
+// for a running scan, and keeps the previous flags if the file is broken.
+func reloadEnabled() {
+	scanMu.Lock()
+	defer scanMu.Unlock()
+
+	previous := disabledAddresses
+	disabledAddresses = make(map[byte]bool)
+	if err := readEnabled(); err != nil {
+		disabledAddresses = previous
+		slog.Error("reloading enabled addresses failed", "file", configFileName, "error", err)
+		return
+	}
+
+	for i := 0; i < numAdresses; i++ {
+		adr := scanAddress[i]
+		if disabledAddresses[adr] != previous[adr] {
+			slog.Info("address polling changed", "address", adr, "enabled", !disabledAddresses[adr])
+		}
+	}
+	publishMetrics()
+}
+
+func readEnabled() error {
+	file, err := os.Open(configFileName)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if key := configKey(line); strings.HasPrefix(key, "enabled.") {
+			if err := setEnabled(key, extractQuotedValue(line)); err != nil {
+				return err
+			}
+		}
+	}
+	return scanner.Err()
+}
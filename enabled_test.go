@@ -0,0 +1,62 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestDisabledAddressSkipped(t *testing.T) {
+	port := &fakePort{reply: func(adr byte, cmd string) []byte {
+		if cmd == "SN ?" {
+			return frame(ACK, []string{1: "S1", 2: "S2", 3: "S3"}[adr])
+		}
+		return frame(ACK, "20")
+	}}
+	stored := scanTest(t, port, 1, 2, 3)
+	setVar(t, &disabledAddresses, map[byte]bool{})
+	if err := setEnabled("enabled.2", "0"); err != nil {
+		t.Fatal(err)
+	}
+
+	scan()
+	for _, c := range port.commands() {
+		if c.adr == 2 {
+			t.Errorf("disabled address 2 sent %q", c.cmd)
+		}
+	}
+	if got := serials(stored.records()); !slices.Equal(got, []string{"S1", "S3"}) {
+		t.Errorf("stored %q, want S1 and S3", got)
+	}
+	publishMetrics()
+	if metricsAddrs[1].Enabled || !metricsAddrs[0].Enabled {
+		t.Error("metrics do not show address 2 disabled")
+	}
+}
+
+func TestReloadEnabled(t *testing.T) {
+	setAddresses(t, 1, 2)
+	setVar(t, &disabledAddresses, map[byte]bool{2: true})
+	setVar(t, &metricsAddrs, nil)
+	file := filepath.Join(t.TempDir(), "test.cfg")
+	setVar(t, &configFileName, file)
+	write := func(content string) {
+		if err := os.WriteFile(file, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	write("enabled.1 = \"0\"\nenabled.2 = \"1\"\n")
+	reloadEnabled()
+	if !disabledAddresses[1] || disabledAddresses[2] {
+		t.Errorf("after the reload disabled %v, want address 1 only", disabledAddresses)
+	}
+
+	// A broken file keeps the flags
+	write("enabled.2 = \"no\"\n")
+	reloadEnabled()
+	if !disabledAddresses[1] || disabledAddresses[2] {
+		t.Errorf("after a failed reload disabled %v, want address 1 only", disabledAddresses)
+	}
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"slices"
+	"strings"
+)
+
+// Status codes the devices report instead of a value. Descriptions are set
+// with "errorCode.<code>" entries, which also declare additional codes.
+var (
+	statusCodes     = []string{"100001", "100002", "100003"}
+	errorCodeText   = make(map[string]string)
+	storeErrorCodes = false
+)
+
+// statusCode returns the status code a device response starts with, or ""
+// for a measured value
+func statusCode(value string) string {
+	for _, code := range statusCodes {
+		if strings.HasPrefix(value, code) {
+			return code
+		}
+	}
+	return ""
+}
+
+// isStatusCode reports whether a device response is a status code rather
+// than a measured value.
+func isStatusCode(value string) bool {
+	return statusCode(value) != ""
+}
+
+// addErrorCode registers the description of a status code
+func addErrorCode(code, text string) {
+	if !slices.Contains(statusCodes, code) {
+		statusCodes = append(statusCodes, code)
+	}
+	errorCodeText[code] = text
+}
+
+// errorDescription returns the description of a status code. Codes without
+// a description are described by the raw code.
+func errorDescription(code string) string {
+	if text, ok := errorCodeText[code]; ok && text != "" {
+		return text
+	}
+	return code
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"database/sql"
+	"testing"
+	"time"
+)
+
+func TestStatusCodes(t *testing.T) {
+	setVar(t, &statusCodes, statusCodes)
+	setVar(t, &errorCodeText, map[string]string{})
+	if err := loadTestConfig(t, `errorCode.100002 = "Sensor Open"`, `errorCode.200007 = "Cold Junction Fault"`,
+		`scanAddresses = "1"`); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		value, code, text string
+	}{
+		{"100001", "100001", "100001"},
+		{"100002", "100002", "Sensor Open"},
+		{"100003 T", "100003", "100003"},
+		{"200007", "200007", "Cold Junction Fault"},
+		{"100042", "", ""}, // a reading, not a configured code
+		{"21.5", "", ""},
+	}
+	for _, tt := range tests {
+		code := statusCode(tt.value)
+		if code != tt.code {
+			t.Errorf("statusCode(%q) = %q, want %q", tt.value, code, tt.code)
+			continue
+		}
+		if code != "" && errorDescription(code) != tt.text {
+			t.Errorf("errorDescription(%q) = %q, want %q", code, errorDescription(code), tt.text)
+		}
+	}
+}
+
+func TestErrorCodesStored(t *testing.T) {
+	sock := testDB(t, unitDDL, dataDDL,
+		"CREATE TABLE channel (id integer PRIMARY KEY, id_unit integer, status text, error_code text, error_text text, error_raw text)")
+	provision(t, sock, "S1", "S2", "S3")
+	setVar(t, &storeErrorCodes, true)
+	setVar(t, &statusCodes, statusCodes)
+	setVar(t, &errorCodeText, map[string]string{})
+	addErrorCode("100002", "Sensor Open")
+	addErrorCode("200007", "Cold Junction Fault")
+	if err := verifySchema(sock); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, rec := range []record{
+		{Serial: "S1", Value: "100002", Time: time.Now()},
+		{Serial: "S2", Value: "100003", Time: time.Now()},
+		{Serial: "S3", Value: "200007 CJ", Time: time.Now()},
+	} {
+		if status := writeToDB(rec); status != 0 {
+			t.Fatalf("writeToDB(%+v) = %d", rec, status)
+		}
+	}
+
+	want := map[int][4]string{
+		1: {"error", "100002", "Sensor Open", "100002"},
+		2: {"error", "100003", "100003", "100003"},
+		3: {"error", "200007", "Cold Junction Fault", "200007 CJ"},
+	}
+	for id, w := range want {
+		var status, code, text, raw sql.NullString
+		err := sock.QueryRow("SELECT status, error_code, error_text, error_raw FROM channel WHERE id = ?", id).
+			Scan(&status, &code, &text, &raw)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := [4]string{status.String, code.String, text.String, raw.String}; got != w {
+			t.Errorf("channel %d stored %q, want %q", id, got, w)
+		}
+	}
+	if n := rowCount(t, sock, "data"); n != 0 {
+		t.Errorf("%d status codes stored as values", n)
+	}
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+	"time"
+)
+
+var extremesInterval time.Duration // 0 = extremes since start
+
+// extreme holds the lowest and highest value seen on a channel
+type extreme struct {
+	Valid bool
+	Min   float64
+	Max   float64
+	MinAt time.Time
+	MaxAt time.Time
+	Since time.Time // start of the current interval
+}
+
+var extremes [MAXNUMADR]extreme
+
+// trackExtremes updates the extremes of the address at index idx with a
+// measured value. Status codes and non numeric values are ignored.
+func trackExtremes(idx int, value string, t time.Time) {
+	if isStatusCode(value) {
+		return
+	}
+	v, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+	if err != nil {
+		return
+	}
+
+	e := &extremes[idx]
+	if e.Valid && extremesInterval > 0 && t.Sub(e.Since) >= extremesInterval {
+		e.Valid = false
+	}
+	if !e.Valid {
+		*e = extreme{Valid: true, Min: v, Max: v, MinAt: t, MaxAt: t, Since: t}
+		return
+	}
+	if v < e.Min {
+		e.Min, e.MinAt = v, t
+	}
+	if v > e.Max {
+		e.Max, e.MaxAt = v, t
+	}
+}
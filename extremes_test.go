@@ -0,0 +1,43 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestExtremesTracked(t *testing.T) {
+	setVar(t, &extremes, [MAXNUMADR]extreme{})
+	setVar(t, &extremesInterval, 0)
+
+	t0 := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	at := func(min int) time.Time { return t0.Add(time.Duration(min) * time.Minute) }
+	for i, v := range []string{"20.0", "18.5", "100001", "22.25", "n/a", "19", " 22.25 "} {
+		trackExtremes(0, v, at(i))
+	}
+
+	e := extremes[0]
+	if !e.Valid || e.Min != 18.5 || e.Max != 22.25 {
+		t.Fatalf("extremes %+v, want min 18.5 and max 22.25", e)
+	}
+	if !e.MinAt.Equal(at(1)) || !e.MaxAt.Equal(at(3)) {
+		t.Errorf("min at %v and max at %v, want %v and the first 22.25 at %v", e.MinAt, e.MaxAt, at(1), at(3))
+	}
+	if !e.Since.Equal(t0) {
+		t.Errorf("since %v, want %v", e.Since, t0)
+	}
+}
+
+func TestExtremesInterval(t *testing.T) {
+	setVar(t, &extremes, [MAXNUMADR]extreme{})
+	setVar(t, &extremesInterval, time.Hour)
+
+	t0 := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	trackExtremes(0, "30", t0)
+	trackExtremes(0, "10", t0.Add(30*time.Minute))
+	trackExtremes(0, "20", t0.Add(time.Hour))
+
+	e := extremes[0]
+	if e.Min != 20 || e.Max != 20 || !e.Since.Equal(t0.Add(time.Hour)) {
+		t.Errorf("extremes after the interval %+v, want a new interval with 20", e)
+	}
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"log/slog"
+	"os"
+	"sync/atomic"
+	"time"
+)
+
+// Heartbeat configuration
+var (
+	heartbeatInterval time.Duration // 0 = no heartbeat
+	heartbeatFile     string        // touched on every heartbeat if set
+)
+
+// heartbeatTicker returns the ticks of the heartbeat and a function
+// stopping them, replaced by the tests
+var heartbeatTicker = func(interval time.Duration) (<-chan time.Time, func()) {
+	ticker := time.NewTicker(interval)
+	return ticker.C, ticker.Stop
+}
+
+var (
+	heartbeats   atomic.Int64
+	scansDone    atomic.Int64
+	lastScanUnix atomic.Int64
+)
+
+// startHeartbeat logs an "alive" record at a fixed interval, independent of
+// the scan loop, so a watchdog can tell a stalled process from a slow one.
+// The returned function stops the heartbeat.
+func startHeartbeat(interval time.Duration) (stop func()) {
+	done, stopped := make(chan struct{}), make(chan struct{})
+	go func() {
+		defer close(stopped)
+		ticks, stopTicks := heartbeatTicker(interval)
+		defer stopTicks()
+		for {
+			select {
+			case <-ticks:
+				heartbeat()
+			case <-done:
+				return
+			}
+		}
+	}()
+	return func() {
+		close(done)
+		<-stopped
+	}
+}
+
+func heartbeat() {
+	n := heartbeats.Add(1)
+
+	var lastScan time.Time
+	if ts := lastScanUnix.Load(); ts != 0 {
+		lastScan = time.Unix(ts, 0)
+	}
+	slog.Info("alive", "heartbeat", n, "scans", scansDone.Load(), "lastScan", lastScan)
+
+	if heartbeatFile != "" {
+		now := time.Now()
+		if err := os.Chtimes(heartbeatFile, now, now); err != nil {
+			if !os.IsNotExist(err) {
+				slog.Error("heartbeat file update failed", "file", heartbeatFile, "error", err)
+				return
+			}
+			if err := os.WriteFile(heartbeatFile, nil, 0644); err != nil {
+				slog.Error("heartbeat file create failed", "file", heartbeatFile, "error", err)
+			}
+		}
+	}
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestHeartbeatCadence(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "alive")
+	setVar(t, &heartbeatFile, file)
+	start := heartbeats.Load()
+
+	ticks := make(chan time.Time)
+	var interval time.Duration
+	tickerStopped := false
+	setVar(t, &heartbeatTicker, func(d time.Duration) (<-chan time.Time, func()) {
+		interval = d
+		return ticks, func() { tickerStopped = true }
+	})
+
+	stop := startHeartbeat(20 * time.Second)
+	for i := 0; i < 5; i++ {
+		ticks <- time.Now()
+	}
+	stop()
+
+	if interval != 20*time.Second {
+		t.Errorf("ticker interval %v, want 20s", interval)
+	}
+	if n := heartbeats.Load() - start; n != 5 {
+		t.Errorf("%d heartbeats for 5 ticks, want 5", n)
+	}
+	if !tickerStopped {
+		t.Error("ticker not stopped")
+	}
+	if _, err := os.Stat(file); err != nil {
+		t.Fatalf("heartbeat file not created: %v", err)
+	}
+
+	// Nothing receives ticks after stop
+	select {
+	case ticks <- time.Now():
+		t.Error("heartbeat still running after stop")
+	default:
+	}
+}
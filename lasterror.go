@@ -0,0 +1,56 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"strings"
+	"time"
+)
+
+var errNAK = errors.New("NAK received")
+
+var exposeLastError = false // last error per address in /status and /metrics
+
+// addrError is the last failure seen on an address
+type addrError struct {
+	Class   string // timeout, bcc, short, nak, io, retries or other
+	Message string
+	Time    time.Time
+	Command string
+}
+
+var (
+	lastError   [MAXNUMADR]addrError
+	lastCommand [MAXNUMADR]string // last command sent to the address
+)
+
+// classifyError maps an error to a short, bounded classification usable as
+// a metric label
+func classifyError(err error) string {
+	switch {
+	case errors.Is(err, errNAK):
+		return "nak"
+	case errors.Is(err, errBCC):
+		return "bcc"
+	case errors.Is(err, errShort):
+		return "short"
+	case errors.Is(err, errRetries):
+		return "retries"
+	case os.IsTimeout(err) || strings.Contains(err.Error(), "no data read"):
+		return "timeout"
+	case isPortIOError(err):
+		return "io"
+	}
+	return "other"
+}
+
+// recordError stores err as the last error of the address at index idx
+func recordError(idx int, cmd string, err error) {
+	lastError[idx] = addrError{Class: classifyError(err), Message: err.Error(), Time: time.Now(), Command: cmd}
+}
+
+// clearError forgets the last error of the address at index idx after a
+// successful measurement
+func clearError(idx int) {
+	lastError[idx] = addrError{}
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestLastError(t *testing.T) {
+	healthy := false
+	port := &fakePort{reply: func(adr byte, cmd string) []byte {
+		if cmd == "SN ?" {
+			return frame(ACK, "S1")
+		}
+		if healthy {
+			return frame(ACK, "20")
+		}
+		f := frame(ACK, "20")
+		f[len(f)-1] ^= 0xff
+		return f
+	}}
+	scanTest(t, port, 1)
+	setVar(t, &exposeLastError, true)
+
+	scan()
+	e := lastError[0]
+	if e.Class != "bcc" || e.Command != "MEA CH 1 ?" || e.Time.IsZero() || e.Message == "" {
+		t.Fatalf("last error %+v, want a bcc error of MEA CH 1 ?", e)
+	}
+	if last, _ := getStatus(t)[0][jsonField("lastError")].(map[string]any); last[jsonField("class")] != "bcc" {
+		t.Errorf("/status last error %v, want the bcc error", last)
+	}
+	if !strings.Contains(getMetrics(t), `class="bcc"`) {
+		t.Error("/metrics without the bcc error")
+	}
+
+	healthy = true
+	scan()
+	if lastError[0] != (addrError{}) {
+		t.Errorf("last error %+v kept after a successful measurement", lastError[0])
+	}
+	if _, ok := getStatus(t)[0][jsonField("lastError")]; ok || strings.Contains(getMetrics(t), "sensor_last_error_timestamp_seconds{") {
+		t.Error("cleared last error still published")
+	}
+}
+
+func TestLastErrorHiddenByDefault(t *testing.T) {
+	port := &fakePort{reply: func(adr byte, cmd string) []byte {
+		f := frame(ACK, "20")
+		if cmd != "SN ?" {
+			f[len(f)-1] ^= 0xff
+		}
+		return f
+	}}
+	scanTest(t, port, 1)
+
+	scan()
+	if lastError[0].Class != "bcc" {
+		t.Fatalf("last error %+v, want a bcc error", lastError[0])
+	}
+	if _, ok := getStatus(t)[0][jsonField("lastError")]; ok || strings.Contains(getMetrics(t), `class="bcc"`) {
+		t.Error("last error published without metrics.lastError")
+	}
+}
+
+func TestClassifyError(t *testing.T) {
+	for err, want := range map[error]string{
+		errNAK:     "nak",
+		errBCC:     "bcc",
+		errShort:   "short",
+		errRetries: "retries",
+	} {
+		if got := classifyError(err); got != want {
+			t.Errorf("classifyError(%v) = %s, want %s", err, got, want)
+		}
+	}
+}
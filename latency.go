@@ -0,0 +1,84 @@
+package main
+
+import (
+	"log/slog"
+	"sort"
+	"time"
+)
+
+const (
+	RESPONSE_DELAY   = 485 * time.Millisecond // historic fixed wait after a command
+	LATENCY_SAMPLES  = 100                    // samples kept per address
+	MIN_ADAPT_SAMPLE = 10                     // samples needed before adapting
+	MIN_RESP_DELAY   = 100 * time.Millisecond
+)
+
+// Response timing configuration
+var (
+	responseDelayMax = RESPONSE_DELAY
+	adaptiveDelay    = false
+)
+
+// latencyWindow keeps the most recent response latencies of one address.
+type latencyWindow struct {
+	firstByte [LATENCY_SAMPLES]time.Duration
+	frame     [LATENCY_SAMPLES]time.Duration
+	next      int
+	filled    int
+	count     int64         // all samples ever recorded
+	sumFirst  time.Duration // sum of all first byte latencies
+	sumFrame  time.Duration // sum of all full frame latencies
+}
+
+var latency [MAXNUMADR]latencyWindow
+
+// recordLatency stores the time from command write to the first received
+// byte and to the complete frame for the address at index idx.
+func recordLatency(idx int, firstByte, frame time.Duration) {
+	w := &latency[idx]
+	w.firstByte[w.next] = firstByte
+	w.frame[w.next] = frame
+	w.next = (w.next + 1) % LATENCY_SAMPLES
+	if w.filled < LATENCY_SAMPLES {
+		w.filled++
+	}
+	w.count++
+	w.sumFirst += firstByte
+	w.sumFrame += frame
+
+	if showValues {
+		slog.Debug("response latency", "address", scanAddress[idx],
+			"firstByte", firstByte, "frame", frame)
+	}
+}
+
+// percentile returns the q-quantile (0..1) of the recorded samples.
+func (w *latencyWindow) percentile(samples *[LATENCY_SAMPLES]time.Duration, q float64) time.Duration {
+	if w.filled == 0 {
+		return 0
+	}
+	sorted := make([]time.Duration, w.filled)
+	copy(sorted, samples[:w.filled])
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	return sorted[int(q*float64(w.filled-1)+0.5)]
+}
+
+// responseDelay returns how long to wait for a response from the address
+// at index idx. With adaptive delay enabled it is derived from the observed
+// full frame latencies, otherwise the configured fixed delay is used.
+func responseDelay(idx int) time.Duration {
+	w := &latency[idx]
+	if !adaptiveDelay || w.filled < MIN_ADAPT_SAMPLE {
+		return responseDelayMax
+	}
+
+	// Allow for 50% above the worst recent response
+	delay := w.percentile(&w.frame, 0.99) * 3 / 2
+	if delay < MIN_RESP_DELAY {
+		delay = MIN_RESP_DELAY
+	}
+	if delay > responseDelayMax {
+		delay = responseDelayMax
+	}
+	return delay
+}
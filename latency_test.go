@@ -0,0 +1,69 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLatencyMeasured(t *testing.T) {
+	setAddresses(t, 5)
+	setVar(t, &latency, [MAXNUMADR]latencyWindow{})
+	setVar(t, &msgSent, [MAXNUMADR]int64{})
+	setVar(t, &msgReceived, [MAXNUMADR]int64{})
+
+	const delay, gap = 40 * time.Millisecond, 10 * time.Millisecond
+	port := &fakePort{
+		reply: func(adr byte, cmd string) []byte { return frame(ACK, "12345") },
+		delay: delay,
+		gap:   gap,
+	}
+	usePort(t, port)
+
+	var resp string
+	status, err := getValue(&resp, "SN ?", 5)
+	if err != nil || status != ACK || resp != "12345" {
+		t.Fatalf("getValue = %d, %q, %v; want ACK, \"12345\", nil", status, resp, err)
+	}
+
+	w := &latency[0]
+	if w.count != 1 {
+		t.Fatalf("recorded %d latencies, want 1", w.count)
+	}
+	// The fake port schedules the answer just before writeDone is taken
+	const slack = 5 * time.Millisecond
+	first, full := w.firstByte[0], w.frame[0]
+	if first < delay-slack || first > delay+fakeReadTimeout {
+		t.Errorf("first byte latency %v, want about %v", first, delay)
+	}
+	if full < delay+gap-slack || full > delay+gap+fakeReadTimeout {
+		t.Errorf("frame latency %v, want about %v", full, delay+gap)
+	}
+	if msgSent[0] != 1 || msgReceived[0] != 1 {
+		t.Errorf("sent %d received %d, want 1 and 1", msgSent[0], msgReceived[0])
+	}
+}
+
+func TestAdaptiveResponseDelay(t *testing.T) {
+	setVar(t, &latency, [MAXNUMADR]latencyWindow{})
+	setVar(t, &adaptiveDelay, true)
+	setVar(t, &responseDelayMax, RESPONSE_DELAY)
+
+	for i := 0; i < MIN_ADAPT_SAMPLE-1; i++ {
+		recordLatency(0, 150*time.Millisecond, 200*time.Millisecond)
+	}
+	if d := responseDelay(0); d != RESPONSE_DELAY {
+		t.Errorf("delay with %d samples = %v, want the fixed %v", MIN_ADAPT_SAMPLE-1, d, RESPONSE_DELAY)
+	}
+
+	recordLatency(0, 150*time.Millisecond, 200*time.Millisecond)
+	if d := responseDelay(0); d != 300*time.Millisecond {
+		t.Errorf("adapted delay = %v, want 300ms", d)
+	}
+
+	for i := 0; i < LATENCY_SAMPLES; i++ {
+		recordLatency(0, 10*time.Millisecond, 20*time.Millisecond)
+	}
+	if d := responseDelay(0); d != MIN_RESP_DELAY {
+		t.Errorf("delay of a fast device = %v, want the minimum %v", d, MIN_RESP_DELAY)
+	}
+}
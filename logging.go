@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+)
+
+// Log file written in addition to stderr
+var (
+	logFile      string // "" = stderr only
+	logFileLevel = slog.LevelInfo
+	stderrLevel  = slog.LevelInfo
+)
+
+// fanoutHandler passes each record to every handler that accepts its level.
+// The slog handlers serialize their own writes, so it is safe for
+// concurrent use.
+type fanoutHandler []slog.Handler
+
+func (h fanoutHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	for _, handler := range h {
+		if handler.Enabled(ctx, level) {
+			return true
+		}
+	}
+	return false
+}
+
+func (h fanoutHandler) Handle(ctx context.Context, r slog.Record) error {
+	var errs []error
+	for _, handler := range h {
+		if handler.Enabled(ctx, r.Level) {
+			errs = append(errs, handler.Handle(ctx, r.Clone()))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (h fanoutHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	out := make(fanoutHandler, len(h))
+	for i, handler := range h {
+		out[i] = handler.WithAttrs(attrs)
+	}
+	return out
+}
+
+func (h fanoutHandler) WithGroup(name string) slog.Handler {
+	out := make(fanoutHandler, len(h))
+	for i, handler := range h {
+		out[i] = handler.WithGroup(name)
+	}
+	return out
+}
+
+// setupLogging adds the configured log file next to stderr and the
+// throttling of repeated messages
+func setupLogging() error {
+	if logFile == "" && logThrottle <= 0 {
+		return nil
+	}
+
+	var handler slog.Handler = slog.NewJSONHandler(os.Stderr, &slog.HandlerOptions{Level: stderrLevel})
+	if logFile != "" {
+		f, err := os.OpenFile(logFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+		if err != nil {
+			return fmt.Errorf("failed to open log file: %w", err)
+		}
+		handler = fanoutHandler{handler, slog.NewJSONHandler(f, &slog.HandlerOptions{Level: logFileLevel})}
+	}
+	if logThrottle > 0 {
+		handler = newThrottleHandler(handler, logThrottle)
+	}
+
+	slog.SetDefault(slog.New(handler))
+	return nil
+}
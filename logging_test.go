@@ -0,0 +1,44 @@
+package main
+
+import (
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLogBothOutputs(t *testing.T) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	setVar(t, &os.Stderr, w)
+	setVar(t, &logFile, filepath.Join(t.TempDir(), "sensor.log"))
+	setVar(t, &logFileLevel, slog.LevelDebug)
+	setVar(t, &stderrLevel, slog.LevelWarn)
+	setVar(t, &logThrottle, 0)
+	old := slog.Default()
+	t.Cleanup(func() { slog.SetDefault(old) })
+
+	if err := setupLogging(); err != nil {
+		t.Fatal(err)
+	}
+	slog.Debug("debug only in the file")
+	slog.Warn("warning in both", "address", 3)
+	w.Close()
+
+	stderr, _ := io.ReadAll(r)
+	file, err := os.ReadFile(logFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(stderr), `"msg":"warning in both","address":3`) ||
+		!strings.Contains(string(file), `"msg":"warning in both","address":3`) {
+		t.Errorf("warning missing from an output:\nstderr: %s\nfile: %s", stderr, file)
+	}
+	if strings.Contains(string(stderr), "debug only") || !strings.Contains(string(file), "debug only") {
+		t.Errorf("debug record not filtered per output:\nstderr: %s\nfile: %s", stderr, file)
+	}
+}
@@ -6,12 +6,14 @@ import (
 	"errors"
 	"fmt"
 	"flag"
+	"io"
 	"log"
 	"log/slog"
 	"os"
 	"os/signal"
 	"strconv"
 	"strings"
+	"sync"
 	"syscall"
 	"time"
 	"unicode"
@@ -45,20 +47,30 @@ type DBAccessData struct {
 }
 
 type SerialPort struct {
-	port *serial.Port
+	port      io.ReadWriteCloser // *serial.Port, or a scripted port in tests
+	writeDone time.Time // end of the last WriteStrPort
+	firstByte time.Time // first byte of the last frame read
+	frameDone time.Time // end of the last frame read
 }
 
 var db DBAccessData
 
 var configFileName string = ""
+var configSchema bool // -configschema: print the config keys and exit
+var showVersion bool  // -version: print the build information and exit
 
 // Configuration
 var (
 	serialDeviceStr      string
 	maxRetrys            = 25
+	snRetrys             = maxRetrys // retries of the serial number query
+	measureRetrys        = maxRetrys // retries of a measurement
 	minScanDelaySeconds  = 60.0 // 0 = no delay
 	numScans        int64 = 1    // 0 = continuous
 	showValues           = true
+	metricsListen        string // "" = no metrics endpoint
+	dbWriteRate          float64 // max database writes per second, 0 = unlimited
+	maxAddressesPerScan  int     // 0 = all addresses every scan
 )
 
 // Device status
@@ -70,6 +82,7 @@ var (
 	adrCounter    int
 	numAdresses   int
 	timestamp     [MAXNUMADR]time.Time
+	measRetries   [MAXNUMADR]int // retries needed by the last measurement
 	msgSent       [MAXNUMADR]int64
 	msgReceived   [MAXNUMADR]int64
 	msgNAK        [MAXNUMADR]int64
@@ -78,16 +91,69 @@ var (
 
 var logger *slog.Logger
 
+// Scan state. scanMu is held while a scan is running.
+var (
+	scanMu        sync.Mutex
+	lastScan      time.Time
+	scanned       [MAXNUMADR]bool // address polled in an earlier scan
+	scanNext      int // first address index of the next scan
+)
+
+// firstScanMode handles the results of the first scan: "store" them like
+// any other, "skip" them or "flag" them with the "startup" quality
+var firstScanMode = "store"
+
+var (
+	errRetries = errors.New("retries exhausted")
+	errBCC     = errors.New("BCC verification failed")
+	errShort   = errors.New("payload too short")
+)
+
+// Minimum payload length per command, 0 = no minimum. A shorter payload
+// is treated as a framing error even if its BCC matched.
+var (
+	minPayloadSN      = 0
+	minPayloadMeasure = 0
+)
+
+// checkPayload rejects a payload shorter than min
+func checkPayload(payload string, min int) error {
+	if len(payload) < min {
+		return fmt.Errorf("%w: %d bytes, want at least %d", errShort, len(payload), min)
+	}
+	return nil
+}
+
 func main() {
 	// Handle cleanup on exit
 	signalChan := make(chan os.Signal, 1)
 	signal.Notify(signalChan, os.Interrupt, syscall.SIGTERM)
 	go func() {
 		<-signalChan
-		cleanup()
-		os.Exit(0)
+		shutdown()
 	}()
 
+	// Reread the enabled addresses on SIGHUP
+	hupChan := make(chan os.Signal, 1)
+	signal.Notify(hupChan, syscall.SIGHUP)
+	go func() {
+		for range hupChan {
+			reloadEnabled()
+		}
+	}()
+
+	// Parse command line arguments
+	parseArgs()
+
+	if configSchema {
+		printConfigSchema(os.Stdout)
+		return
+	}
+	if showVersion {
+		printVersion(os.Stdout)
+		return
+	}
+
 	// Check for lock file
 	if _, err := os.Stat(LOCK_FILE); err == nil {
 		log.Fatal("Lock file exists - another instance may be running")
@@ -99,14 +165,36 @@ func main() {
 	}
 	defer os.Remove(LOCK_FILE)
 
-	// Parse command line arguments
-	parseArgs()
-
 	// Load configuration
 	if err := loadConfig(); err != nil {
 		log.Fatalf("Failed to load config: %v", err)
 	}
 
+	if err := setupLogging(); err != nil {
+		log.Fatalf("Failed to set up logging: %v", err)
+	}
+
+	// Check the database schema before collecting anything
+	if verifySchemaAtStart {
+		sock, err := openDB()
+		if err != nil {
+			log.Fatalf("Schema check failed: %v", err)
+		}
+		err = verifySchema(sock)
+		sock.Close()
+		if err != nil {
+			log.Fatalf("Schema check failed: %v", err)
+		}
+	}
+
+	// Report configured addresses without a unit in the database. Only a
+	// report, the collector runs without it.
+	if checkUnitsAtStart {
+		if err := checkConfiguredUnits(); err != nil {
+			slog.Error("unit check failed", "error", err)
+		}
+	}
+
 	// Initialize counters
 	for i := 0; i < MAXNUMADR; i++ {
 		msgSent[i] = 0
@@ -114,16 +202,37 @@ func main() {
 		msgNAK[i] = 0
 	}
 
+	if metricsListen != "" {
+		publishMetrics()
+		startMetricsServer(metricsListen)
+	}
+
+	if heartbeatInterval > 0 {
+		startHeartbeat(heartbeatInterval)
+	}
+
 	// Main loop
 	numScansMain := numScans
 
-	var lastScan time.Time
+	sdInit()
 
 	for numScans == 0 || numScansMain > 0 {
+		sdWatchdog()
+
+		// Checked on every pass, a scan may not start for a long time
+		checkCounterReset(time.Now())
+
+		// No serial traffic during quiet hours. A start inside them is
+		// ready without a scan, systemd would time out the start otherwise.
+		if quietHours(time.Now()) {
+			sdReady()
+			time.Sleep(time.Second)
+			continue
+		}
 
-		// Wait for minimum scan delay
-		if time.Since(lastScan) < time.Duration(minScanDelaySeconds*float64(time.Second)) {
-			time.Sleep(250 * time.Millisecond)
+		// Wait for the minimum scan delay or the next aligned slot
+		if wait := scanWait(time.Now()); wait > 0 {
+			time.Sleep(min(wait, 250*time.Millisecond))
 			continue
 		}
 
@@ -131,57 +240,202 @@ func main() {
 			numScansMain--
 		}
 
-		// Open serial port
-		if err := openPort(serialDeviceStr); err != nil {
-			log.Printf("Failed to open port: %v", err)
+		scanMu.Lock()
+		importBackfill()
+		err := scan()
+		scanMu.Unlock()
+		scheduleNextScan(time.Now())
+		if err != nil {
+			portOpenFailed(err)
 			continue
 		}
+		sdReady()
+	}
+
+	flushAudit()
+	if printSummary {
+		writeSummary(os.Stdout)
+	}
+}
+
+// scan polls every configured address once and stores the results
+func scan() error {
+	// Open serial port
+	if err := openPort(serialDeviceStr); err != nil {
+		return err
+	}
+	portOpened()
 
-		// Dummy read
-		if _, _, err := serialPort.ReadStrPort(); err != nil && showValues {
-			slog.Error("Dummy read error:", "error", err)
+	// Give the adapter and devices time to settle after the open
+	if portOpenDelay > 0 {
+		time.Sleep(portOpenDelay)
+	}
+
+	// Listen to the idle bus before any command is sent
+	if noiseSample > 0 {
+		sampleBusNoise(noiseSample)
+	}
+
+	// Dummy read
+	if _, _, err := serialPort.ReadStrPort(); err != nil && showValues {
+		slog.Error("Dummy read error:", "error", err)
+	}
+
+	//scanStart := time.Now()
+	
+	// Removed unused scanStartT
+	batch := enabledOnly(scanBatch())
+	polled := batch[:0:0] // addresses actually polled, the ones stored
+	for _, adrCounter = range batch {
+		sdWatchdog()
+
+		// Leave devices alone while they recover from a reset
+		if resetting(adrCounter) {
+			continue
 		}
+		polled = append(polled, adrCounter)
 
-		//scanStart := time.Now()
-		
-		// Removed unused scanStartT
-		for adrCounter = 0; adrCounter < numAdresses; adrCounter++ {
-			// Get serial number
-			if err := getSerialNumber(); err != nil && showValues {
-				slog.Debug("SN Error for address", "address", scanAddress[adrCounter], "error", err)
-			}
+		attempt := time.Now()
 
-			// Get measurement
-			if err := getMeasurement(); err != nil && showValues {
-				slog.Debug("Measurement Error for address", "address", scanAddress[adrCounter], "error", err)
-			}
+		// Get serial number
+		if err := getSerialNumber(); err != nil && showValues {
+			slog.Debug("SN Error for address", "address", scanAddress[adrCounter], "error", err)
+		}
 
-			time.Sleep(100 * time.Millisecond)
+		// Get measurement
+		err := getMeasurement()
+		if err != nil && showValues {
+			slog.Debug("Measurement Error for address", "address", scanAddress[adrCounter], "error", err)
 		}
+		if err == nil {
+			clearError(adrCounter)
+		} else if lastError[adrCounter].Time.Before(attempt) {
+			// Failures after a valid frame, e.g. a short payload
+			recordError(adrCounter, lastCommand[adrCounter], err)
+		}
+		scanResult(adrCounter, err == nil)
+		countMeasurement(adrCounter, err == nil)
 
-		//scanEnd := time.Now()
-		//scanDuration = scanEnd.Sub(scanStart)
-		lastScan = time.Now()
+		// Occasional slow high precision measurement
+		if err == nil && precisionDue(adrCounter) {
+			getPrecision(adrCounter)
+		}
 
-		// Write to database
-		for adrCounter := 0; adrCounter < numAdresses; adrCounter++ {
-			if status := writeToPostgres(serNoStr[adrCounter], valueStr[adrCounter], timestamp[adrCounter]); status != 0 {
+		time.Sleep(100 * time.Millisecond)
+	}
+
+	//scanEnd := time.Now()
+	//scanDuration = scanEnd.Sub(scanStart)
+	lastScan = time.Now()
+	scansDone.Add(1)
+	lastScanUnix.Store(lastScan.Unix())
+
+	// Write to database
+	for _, adrCounter := range polled {
+		// Devices may still be initializing during their first scan
+		startup := !scanned[adrCounter]
+		scanned[adrCounter] = true
+		if startup && firstScanMode == "skip" {
+			slog.Info("not storing the first scan of the address", "address", scanAddress[adrCounter])
+			continue
+		}
+
+		checkScannedUnit(adrCounter)
+
+		rec := record{
+			Serial: serNoStr[adrCounter],
+			Value:  valueStr[adrCounter],
+			Type:   measureType(adrCounter),
+			Time:   timestamp[adrCounter],
+
+			Retries:  measRetries[adrCounter],
+			Unit:     valueUnit[adrCounter],
+			Raw:      rawValue[adrCounter],
+			Tag:      deviceTag[adrCounter],
+			Settings: deviceSettings[adrCounter],
+			Runtime:  deviceRuntime[adrCounter],
+			Device:   readDevice[adrCounter],
+			Source:   sourceTag,
+			Quality:  "normal",
+		}
+		if startup && firstScanMode == "flag" {
+			rec.Quality = "startup"
+		}
+		recs := vectorRecords(adrCounter, rec)
+		for _, rec := range recs {
+			if !validValue(adrCounter, rec.Value) {
+				continue
+			}
+			if len(recs) == 1 {
+				checkAlarm(adrCounter, rec.Value)
+			}
+			if status := storeRecord(rec); status != 0 {
 				if showValues {
 					slog.Debug("database write failed", "status", status)
 				}
+			} else {
+				storedValues++
 			}
 		}
 
-		// Close port
-		if err := serialPort.Close(); err != nil {
-			slog.Error("Failed to close port", "error", err)
+		// High precision result, stored under its own type
+		if precisionPending[adrCounter] {
+			precisionPending[adrCounter] = false
+			rec.Value = precisionValue[adrCounter]
+			rec.Type = precisionType
+			rec.Time = precisionTime[adrCounter]
+			rec.Retries = 0
+			rec.Unit, rec.Raw = deviceUnit[adrCounter], ""
+			if rec = convertUnit(rec); validValue(adrCounter, rec.Value) {
+				if status := storeRecord(rec); status != 0 {
+					slog.Debug("database write failed", "status", status)
+				} else {
+					storedValues++
+				}
+			}
 		}
 	}
+
+	// Close port
+	if err := serialPort.Close(); err != nil {
+		slog.Error("Failed to close port", "error", err)
+	}
+
+	publishMetrics()
+
+	return nil
+}
+
+// scanBatch returns the address indexes to poll in this scan. With
+// maxAddressesPerScan set, each scan continues where the last one stopped
+// so every address is covered once per full pass.
+func scanBatch() []int {
+	n := numAdresses
+	if maxAddressesPerScan > 0 && maxAddressesPerScan < n {
+		n = maxAddressesPerScan
+	}
+	batch := make([]int, n)
+	for i := range batch {
+		batch[i] = (scanNext + i) % numAdresses
+	}
+	scanNext = (scanNext + n) % numAdresses
+	return batch
 }
 
+// portDevice is the device path of the open port, which differs from
+// serialDeviceStr after a reconnect to a device matching the pattern.
+// readDevice holds the path each address was last measured on.
+var (
+	portDevice string
+	readDevice [MAXNUMADR]string
+)
+
 func openPort(devStr string) error {
 	var err error
 	serialPort, err = OpenPort(devStr)
+	if err == nil {
+		portDevice = devStr
+	}
 	return err
 }
 
@@ -203,11 +457,15 @@ func parseArgs() {
 
 	// Set up command-line flags
 	logLevelArg := flag.String("loglevel", "info", "Log level (debug, info, warn, error)")
+	flag.BoolVar(&printSummary, "summary", false, "Print a JSON summary of the run to stdout on exit")
+	flag.BoolVar(&showVersion, "version", false, "Print the version and exit")
+	flag.BoolVar(&configSchema, "configschema", false, "Print all config keys with type, default and description, then exit")
 	flag.Parse()
 
 	// Configure logger
+	stderrLevel = parseLogLevel(*logLevelArg)
 	logger := slog.New(slog.NewJSONHandler(os.Stderr, &slog.HandlerOptions{
-		Level: parseLogLevel(*logLevelArg),
+		Level: stderrLevel,
 	}))
 	slog.SetDefault(logger) // Make it the default logger
 }
@@ -242,32 +500,34 @@ func loadConfig() error {
 
 	for scanner.Scan() {
 		line := scanner.Text()
-		switch {
-		case strings.Contains(line, "db.host"):
-			db.Host = extractQuotedValue(line)
-		case strings.Contains(line, "db.user"):
-			db.User = extractQuotedValue(line)
-		case strings.Contains(line, "db.passwd"):
-			db.Passwd = extractQuotedValue(line)
-		case strings.Contains(line, "db.name"):
-			db.Name = extractQuotedValue(line)
-		case strings.Contains(line, "SerialDevice"):
-			serialDeviceStr = extractQuotedValue(line)
-		case strings.Contains(line, "minScanDelaySeconds"):
-			if val, err := strconv.ParseFloat(extractQuotedValue(line), 64); err == nil {
-				minScanDelaySeconds = val
-			}
-		case strings.Contains(line, "numberOfScans"):
-			if val, err := strconv.ParseInt(extractQuotedValue(line), 10, 64); err == nil {
-				numScans = val
-			}
-		case strings.Contains(line, "scanAddresses"):
+		key := configKey(line)
+		if key == "" || strings.HasPrefix(key, "#") || !strings.Contains(line, "=") {
+			continue
+		}
+		if key == "scanAddresses" {
 			scanAddressesStr = extractAddresses(line, scanner)
+			continue
+		}
+		k := lookupConfigKey(key)
+		if k == nil {
+			slog.Warn("unknown config key", "key", key)
+			continue
 		}
+		if err := k.apply(key, extractQuotedValue(line)); errors.Is(err, errConfigValue) {
+			slog.Warn("ignoring config value", "error", err)
+		} else if err != nil {
+			return err
+		}
+	}
+	deriveConfig()
+	if err := setupStore(); err != nil {
+		return err
 	}
 
 	if scanAddressesStr != "" {
-		extractAdresses(scanAddressesStr)
+		if extractAdresses(scanAddressesStr) == 0 {
+			return fmt.Errorf("no valid scan addresses in %q", scanAddressesStr)
+		}
 	} else {
 		return errors.New("no scan addresses configured")
 	}
@@ -279,6 +539,12 @@ func loadConfig() error {
 	return scanner.Err()
 }
 
+// configKey returns the key of a "key = value" config line
+func configKey(s string) string {
+	key, _, _ := strings.Cut(s, "=")
+	return strings.TrimSpace(key)
+}
+
 func extractQuotedValue(s string) string {
 	start := strings.Index(s, "\"")
 	if start == -1 {
@@ -328,6 +594,11 @@ func extractAdresses(astr string) int {
 	return numAdresses
 }
 
+// openSerial opens a serial device, replaced by a scripted port in tests
+var openSerial = func(config *serial.Config) (io.ReadWriteCloser, error) {
+	return serial.OpenPort(config)
+}
+
 func OpenPort(devStr string) (*SerialPort, error) {
 	config := &serial.Config{
 		Name:        devStr,
@@ -338,7 +609,7 @@ func OpenPort(devStr string) (*SerialPort, error) {
 		ReadTimeout: 100 * time.Millisecond,
 	}
 
-	port, err := serial.OpenPort(config)
+	port, err := openSerial(config)
 	if err != nil {
 		return nil, fmt.Errorf("failed to open port %s: %w", devStr, err)
 	}
@@ -396,22 +667,53 @@ func (sp *SerialPort) WriteStrPort(chars string, adr byte) error {
 		slog.Debug("incomplete write", "expected", a, "wrote", n)
 		return fmt.Errorf("incomplete write, expected %d, wrote %d", a, n)
 	}
+	sp.writeDone = time.Now()
 
 	return nil
 }
 
 func (sp *SerialPort) ReadStrPort() (byte, string, error) {
-	result := make([]byte, RXBUFFLEN)
+	return sp.ReadFrame(time.Time{})
+}
 
-	// Read with timeout is handled by the serial port config
-	iIn, err := sp.port.Read(result)
-	if err != nil {
-		if os.IsTimeout(err) {
-			return 0x00, "", fmt.Errorf("read timeout: %w", err)
+// ReadFrame reads one response frame (status, payload, ETX, BCC). It keeps
+// reading until the frame is complete, the line goes quiet after data has
+// arrived, or the deadline passes without any data. A zero deadline waits
+// for a single read timeout only.
+func (sp *SerialPort) ReadFrame(deadline time.Time) (byte, string, error) {
+	result := make([]byte, 0, RXBUFFLEN)
+	buf := make([]byte, RXBUFFLEN)
+
+	sp.firstByte = time.Time{}
+	sp.frameDone = time.Time{}
+
+	for len(result) < RXBUFFLEN {
+		// Read with timeout is handled by the serial port config
+		n, err := sp.port.Read(buf[:RXBUFFLEN-len(result)])
+		if n > 0 {
+			if sp.firstByte.IsZero() {
+				sp.firstByte = time.Now()
+			}
+			result = append(result, buf[:n]...)
+			if frameComplete(result) {
+				break
+			}
+			continue
+		}
+		if err != nil && err != io.EOF {
+			if os.IsTimeout(err) {
+				return 0x00, "", fmt.Errorf("read timeout: %w", err)
+			}
+			return 0x00, "", fmt.Errorf("serial read error: %w", err)
+		}
+		// Read timed out: stop once data has started or the deadline is gone
+		if len(result) > 0 || !time.Now().Before(deadline) {
+			break
 		}
-		return 0x00, "", fmt.Errorf("serial read error: %w", err)
 	}
+	sp.frameDone = time.Now()
 
+	iIn := len(result)
 	if iIn <= 0 {
 		return 0x00, "", errors.New("no data read")
 	}
@@ -424,14 +726,33 @@ func (sp *SerialPort) ReadStrPort() (byte, string, error) {
 
 	// Verify BCC
 	if bcc != result[iIn-1] {
-		return 0x00, "", errors.New("BCC verification failed")
+		return 0x00, "", errBCC
 	}
 
-	// Replace BCC with string terminator
-	result[iIn-1] = 0x00
+	// Return first byte of result (address) and the payload without BCC
+	return result[0], string(result[1 : iIn-1]), nil
+}
+
+// altTerminator is a control byte some firmware variants end frames with
+// instead of ETX, e.g. CR; -1 = ETX only
+var altTerminator = -1
+
+// terminatorIndex returns the position of the first ETX or alternate
+// terminator in buf, -1 if there is none
+func terminatorIndex(buf []byte) int {
+	for i, b := range buf {
+		if b == ETX || int(b) == altTerminator {
+			return i
+		}
+	}
+	return -1
+}
 
-	// Return first byte of result (address)
-	return result[0], "", nil
+// frameComplete reports whether buf holds a full frame: ETX, or the
+// alternate terminator, followed by BCC.
+func frameComplete(buf []byte) bool {
+	etxPos := terminatorIndex(buf[1:])
+	return etxPos != -1 && len(buf) >= etxPos+3
 }
 
 func (sp *SerialPort) Close() error {
@@ -448,12 +769,20 @@ func getSerialNumber() error {
 	var err error
 
 	retryCnt[adrCounter] = 0
-	for ; retryCnt[adrCounter] < maxRetrys; retryCnt[adrCounter]++ {
+	for ; retryCnt[adrCounter] < snRetrys; retryCnt[adrCounter]++ {
 		portStatus, err = getValue(&serNoStr[adrCounter], cmd, scanAddress[adrCounter])
+		if err == nil {
+			err = checkPayload(serNoStr[adrCounter], minPayloadSN)
+		}
 		if err == nil && portStatus >= 0 {
 			if showValues {
 				slog.Debug("getSerialNumber", "Serialnumber", serNoStr[adrCounter])
 			}
+			checkSerial(adrCounter, serNoStr[adrCounter])
+			queryUnit(adrCounter)
+			queryTag(adrCounter)
+			querySettings(adrCounter)
+			queryRuntime(adrCounter)
 			break
 		} else if portStatus == NAK {
 			msgNAK[adrCounter]++
@@ -470,7 +799,7 @@ func getSerialNumber() error {
 }
 
 func getMeasurement() error {
-	cmd := "MEA CH 1 ?"
+	cmds := measureCommands(adrCounter)
 	var portStatus int
 	var err error
 
@@ -478,20 +807,28 @@ func getMeasurement() error {
 		slog.Error("Dummy read error:", "error", err)
 	}
 
-	for ; retryCnt[adrCounter] < maxRetrys; retryCnt[adrCounter]++ {
-		portStatus, err = getValue(&valueStr[adrCounter], cmd, scanAddress[adrCounter])
+	retryCnt[adrCounter] = 0
+	for ; retryCnt[adrCounter] < measureRetrys; retryCnt[adrCounter]++ {
+		portStatus, err = runSequence(&valueStr[adrCounter], cmds, scanAddress[adrCounter])
 		if err == nil && portStatus == ACK {
 			if showValues {
 				slog.Debug("Measurement", "SN", serNoStr[adrCounter], "Theta", valueStr[adrCounter], 
 					"TX", msgSent[adrCounter], "RX", msgReceived[adrCounter], "NAK", msgNAK[adrCounter])
 			}
 			timestamp[adrCounter] = time.Now()
+			measRetries[adrCounter] = retryCnt[adrCounter]
+			readDevice[adrCounter] = portDevice
+			convertMeasured(adrCounter)
+			trackExtremes(adrCounter, valueStr[adrCounter], timestamp[adrCounter])
 			break
 		} else if portStatus == NAK {
 			msgNAK[adrCounter]++
 			continue
 		}
 	}
+	if retryCnt[adrCounter] >= measureRetrys && err == nil {
+		err = errRetries
+	}
 	return err
 }
 
@@ -502,31 +839,47 @@ func getValue(resultStr *string, cmdStr string, adr byte) (int, error) {
 
     *resultStr = ""
 
+	lastCommand[adrCounter] = cmdStr
 	if err := serialPort.WriteStrPort(cmdStr, adr); err != nil {
 		if showValues {
 			slog.Error("write failed:", "error", err)
 		}
+		recordError(adrCounter, cmdStr, err)
+		checkPortError(err)
 		return 0, err
 	}
 
-	msgSent[adr]++
-	time.Sleep(485 * time.Millisecond)
+	msgSent[adrCounter]++
 
-	readChar, bufStr, err := serialPort.ReadStrPort()
+	delay := responseDelay(adrCounter)
+	if delayOverride > 0 {
+		delay = delayOverride
+	}
+	readChar, bufStr, err := serialPort.ReadFrame(serialPort.writeDone.Add(delay))
+	// Responses outside the regular wait would skew the adaptive delay
+	if !serialPort.firstByte.IsZero() && delayOverride == 0 {
+		recordLatency(adrCounter, serialPort.firstByte.Sub(serialPort.writeDone),
+			serialPort.frameDone.Sub(serialPort.writeDone))
+	}
+	checkPortError(err)
 	if err != nil {
 		if showValues {
 			slog.Debug("read failed: error", "error", err)
 		}
+		recordError(adrCounter, cmdStr, err)
 		return 0, err
 	}
 
-	msgReceived[adr]++
+	msgReceived[adrCounter]++
+	if readChar == NAK {
+		recordError(adrCounter, cmdStr, errNAK)
+	}
 
 	// Convert string to []byte for ETX processing
     buf := []byte(bufStr)
 
 	// Find ETX and truncate
-    if etxPos := bytes.IndexByte(buf, ETX); etxPos != -1 {
+    if etxPos := terminatorIndex(buf); etxPos != -1 {
         buf = buf[:etxPos]
     }
 
@@ -546,123 +899,363 @@ func getValue(resultStr *string, cmdStr string, adr byte) (int, error) {
     return int(readChar), nil
 }
 
-func writeToDB(serNoStr, valueStr string, t time.Time) int {// Connect to database
-	// Connect to database
-	dsn := fmt.Sprintf("%s:%s@tcp(%s)/%s", db.User, db.Passwd, db.Host, db.Name)
-	sock, err := sql.Open("mysql", dsn)
+// openDB connects to the configured database and verifies the connection
+func openDB() (*sql.DB, error) {
+	var dsn string
+	switch dbDriver {
+	case "mysql":
+		dsn = fmt.Sprintf("%s:%s@tcp(%s)/%s", db.User, db.Passwd, db.Host, db.Name)
+	case "sqlite":
+		dsn = db.Name // database file, used by the tests
+	default:
+		dsn = fmt.Sprintf("host=%s user=%s password=%s dbname=%s sslmode=disable",
+			db.Host, db.User, db.Passwd, db.Name)
+	}
+	sock, err := sql.Open(dbDriver, dsn)
 	if err != nil {
-		fmt.Errorf("database connection failed: %v", err)
-		slog.Debug("database connection failed", "dsn", dsn)
-		return 1
+		return nil, fmt.Errorf("database open failed: %w", err)
 	}
-	defer sock.Close()
 
 	// Verify connection
 	if err = sock.Ping(); err != nil {
-		fmt.Errorf("database ping failed: %v", err)
-		slog.Debug("database ping failed", "dsn", dsn)
-		return 1
+		sock.Close()
+		return nil, fmt.Errorf("database ping failed: %w", err)
+	}
+	return sock, nil
+}
+
+// record is one measurement to be stored
+type record struct {
+	Serial string    // device serial number
+	Value  string    // measured value as reported
+	Type   string    // measurement type, selects the data table
+	Time   time.Time // time of the measurement
+
+	Retries  int    // retries until a valid frame was received, 0 = first try
+	Unit     string // measurement unit reported by the device
+	Raw      string // value before unit conversion, "" = not converted
+	Tag      string // location tag programmed into the device
+	Settings string // averaging/sample rate settings of the device
+	Runtime  string // operating hours counter of the device
+	Device   string // serial device path the reading came from
+	Source   string // how the row arrived: "live", "import" or "buffer"
+	Index    int    // position of the value in a vector response
+	Quality  string // "startup" for the first scan, otherwise "normal"
+}
+
+var lastDBWrite time.Time
+
+// Clock of the write pacing, replaced in tests
+var (
+	paceNow   = time.Now
+	paceSleep = time.Sleep
+)
+
+// lookupRetries is how often a channel lookup failing for a reason other
+// than an unknown serial number is retried
+var lookupRetries = 0
+
+const LOOKUP_RETRY_DELAY = 500 * time.Millisecond
+
+// Backoff of the channel lookup retries, doubling per retry; replaced in
+// tests
+var (
+	lookupRetryDelay = LOOKUP_RETRY_DELAY
+	lookupSleep      = time.Sleep
+)
+
+// paceDBWrite spreads database writes to at most dbWriteRate per second so
+// the burst after a scan does not hit a shared database all at once.
+func paceDBWrite() {
+	if dbWriteRate <= 0 {
+		return
+	}
+	gap := time.Duration(float64(time.Second) / dbWriteRate)
+	if wait := gap - paceNow().Sub(lastDBWrite); wait > 0 {
+		paceSleep(wait)
 	}
+	lastDBWrite = paceNow()
+}
 
+// lastStatus is the status last written per channel id, used to skip
+// redundant updates when statusOnChange is set
+var (
+	statusOnChange = false
+	lastStatus     = make(map[int]string)
+)
+
+// updateStatus sets the status of a channel
+func updateStatus(sock *sql.DB, idChannel int, status string) error {
+	if statusOnChange {
+		if last, ok := lastStatus[idChannel]; ok && last == status {
+			return nil
+		}
+	}
+
+	cols := []string{col("channel.status")}
+	args := []any{status}
+	if storeErrorCodes {
+		// Status codes go to their own columns with the complete response,
+		// the status only says "error"
+		var code, text, raw any
+		if c := statusCode(status); c != "" {
+			args[0], code, text, raw = "error", c, errorDescription(c), status
+		}
+		cols = append(cols, col("channel.error_code"), col("channel.error_text"), col("channel.error_raw"))
+		args = append(args, code, text, raw)
+	}
+
+	set := make([]string, len(cols))
+	for i, c := range cols {
+		set[i] = c + " = " + placeholder(i+1)
+	}
+	qbuf := fmt.Sprintf("UPDATE %s SET %s WHERE %s = %s",
+		tbl("channel"), strings.Join(set, ", "), col("channel.id"), placeholder(len(cols)+1))
+	if _, err := sock.Exec(qbuf, append(args, idChannel)...); err != nil {
+		delete(lastStatus, idChannel)
+		return fmt.Errorf("status update failed: %w", err)
+	}
+	lastStatus[idChannel] = status
+	auditStatus(idChannel, status, time.Now())
+	return nil
+}
+
+// dataColumns returns the data table columns and values of a record
+func dataColumns(rec record, idChannel int, value any) ([]string, []any) {
+	cols := []string{col("data.id_channel"), col("data.datetime"), col("data.value")}
+	args := []any{idChannel, makeDatetime(rec.Time), value}
+
+	if storeQuality {
+		cols = append(cols, col("data.first_try"), col("data.retries"))
+		args = append(args, rec.Retries == 0, rec.Retries)
+	}
+	if storeUnit {
+		cols = append(cols, col("data.unit"))
+		args = append(args, rec.Unit)
+	}
+	if storeQualityFlag {
+		cols = append(cols, col("data.quality"))
+		args = append(args, rec.Quality)
+	}
+	if storeIndex {
+		cols = append(cols, col("data.value_index"))
+		args = append(args, rec.Index)
+	}
+	if storeTag {
+		cols = append(cols, col("data.tag"))
+		args = append(args, rec.Tag)
+	}
+	if storeSettings {
+		cols = append(cols, col("data.settings"))
+		args = append(args, rec.Settings)
+	}
+	if storeRuntime {
+		cols = append(cols, col("data.runtime_hours"))
+		args = append(args, rec.Runtime)
+	}
+	if storeDevice {
+		cols = append(cols, col("data.device"))
+		args = append(args, rec.Device)
+	}
+	if storeSource {
+		cols = append(cols, col("data.source"))
+		args = append(args, rec.Source)
+	}
+	if storeRawValue {
+		raw := rec.Raw
+		if raw == "" {
+			raw = rec.Value
+		}
+		cols = append(cols, col("data.raw_value"))
+		args = append(args, raw)
+	}
+	if storeChecksum {
+		cols = append(cols, col("data.checksum"))
+		args = append(args, recordChecksum(rec))
+	}
+	return cols, args
+}
+
+// valueType declares the type of the data value column: "text" or "numeric"
+var valueType = "text"
+
+// coerceValue converts a measured value to the type of the value column
+func coerceValue(value string) (any, error) {
+	if valueType != "numeric" {
+		return value, nil
+	}
+	v, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+	if err != nil {
+		return nil, fmt.Errorf("value %q is not numeric", value)
+	}
+	return v, nil
+}
+
+// checkValue returns the value a store writes for rec and whether it is a
+// device status code. A value the value column cannot hold is logged and
+// reported with ok false, the stores return status 6 for it.
+func checkValue(rec record) (value any, isStatus, ok bool) {
+	if isStatusCode(rec.Value) {
+		return rec.Value, true, true
+	}
+	value, err := coerceValue(rec.Value)
+	if err != nil {
+		slog.Debug("invalid measurement value", "serNoStr", rec.Serial, "error", err)
+		return nil, false, false
+	}
+	return value, false, true
+}
+
+func writeToDB(rec record) int {
+	// Reject values the value column cannot hold before touching the database
+	value, isStatus, ok := checkValue(rec)
+	if !ok {
+		return 6
+	}
+
+	paceDBWrite()
+
+	// Connect to database
+	sock, err := openDB()
+	if err != nil {
+		slog.Error("database connection failed", "driver", dbDriver, "error", err)
+		return 1
+	}
+	defer sock.Close()
 
 	// Get channel ID
 	var idChannel int
-	query := "SELECT channel.id FROM channel LEFT JOIN unit ON channel.id_unit = unit.id WHERE unit.serialnumber = ?"
-	row := sock.QueryRow(query, serNoStr)
-	if err := row.Scan(&idChannel); err != nil {
+	query := channelQuery()
+	for try := 0; ; try++ {
+		err := sock.QueryRow(query, rec.Serial).Scan(&idChannel)
+		if err == nil {
+			break
+		}
 		if err == sql.ErrNoRows {
+			// Unknown serial number, retrying will not help
+			slog.Debug("DB", "query", query, "serNoStr", rec.Serial)
 			return 3
 		}
-		return 2
+		if try >= lookupRetries {
+			slog.Error("channel lookup failed", "serNoStr", rec.Serial, "tries", try+1, "error", err)
+			return 2
+		}
+		slog.Debug("channel lookup failed, retrying", "serNoStr", rec.Serial, "error", err)
+		lookupSleep(lookupRetryDelay << try)
 	}
 
-	// Prepare to write data
-	var qbuf string
-	if strings.HasPrefix(valueStr, "100003") || strings.HasPrefix(valueStr, "100002") || strings.HasPrefix(valueStr, "100001") {
-		qbuf = fmt.Sprintf("UPDATE `channel` SET `status`='%s' WHERE `id`='%d'", valueStr, idChannel)
-	} else {
-		// Write status
-		qbuf = fmt.Sprintf("UPDATE `channel` SET `status`='%s' WHERE `id`='%d'", "normal", idChannel)
-		if _, err := sock.Exec(qbuf); err != nil {
-			return 4
+	// A status code only updates the channel status
+	if isStatus {
+		if err := updateStatus(sock, idChannel, rec.Value); err != nil {
+			slog.Error("status update failed", "serNoStr", rec.Serial, "error", err)
+			return 5
 		}
+		return 0
+	}
 
-		// Prepare data insert
-		qbuf = fmt.Sprintf("INSERT INTO `data` (`id_channel`,`datetime`,`value`) VALUES ('%d','%s','%s')", 
-			idChannel, makeDatetime(t), valueStr)
+	// Write status
+	if err := updateStatus(sock, idChannel, "normal"); err != nil {
+		slog.Error("status update failed", "serNoStr", rec.Serial, "error", err)
+		return 4
 	}
 
-	// Execute the final query
-	if _, err := sock.Exec(qbuf); err != nil {
+	// Write data
+	cols, args := dataColumns(rec, idChannel, value)
+	qbuf := fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s)",
+		dataTable(rec.Type), strings.Join(cols, ", "), placeholders(len(args)))
+	if _, err := sock.Exec(qbuf, args...); err != nil {
+		slog.Error("database insert failed", "serNoStr", rec.Serial, "query", qbuf, "error", err)
 		return 5
 	}
 
 	return 0
 }
 
-func writeToPostgres(serNoStr, valueStr string, t time.Time) int {
-    // Connect to database
-    dsn := fmt.Sprintf("host=%s user=%s password=%s dbname=%s sslmode=disable", 
-        db.Host, db.User, db.Passwd, db.Name)
-    sock, err := sql.Open("postgres", dsn)
-    if err != nil {
-        fmt.Errorf("database connection failed: %v", err)
-        slog.Debug("database connection failed", "dsn", dsn)
-        return 1
-    }
-    defer sock.Close()
-
-    // Verify connection
-    if err = sock.Ping(); err != nil {
-        fmt.Errorf("database ping failed: %v", err)
-        slog.Debug("database ping failed", "dsn", dsn)
-        return 1
-    }
+// datetimeFormat selects how makeDatetime formats timestamps: "default"
+// (MySQL datetime), "iso8601", "iso8601utc" or a Go time layout
+var datetimeFormat = "default"
 
-    // Get channel ID
-    var idChannel int
-    query := "SELECT channel.id FROM channel LEFT JOIN unit ON channel.id_unit = unit.id WHERE unit.serialnumber = $1"
-    row := sock.QueryRow(query, serNoStr)
-    if err := row.Scan(&idChannel); err != nil {
-        if err == sql.ErrNoRows {
-			slog.Debug("DB", "query", query, "serNoStr", serNoStr);
-            return 3
-        }
-        return 2
-    }
-
-    // Prepare to write data
-    var qbuf string
-    if strings.HasPrefix(valueStr, "100003") || strings.HasPrefix(valueStr, "100002") || strings.HasPrefix(valueStr, "100001") {
-        qbuf = fmt.Sprintf("UPDATE channel SET status='%s' WHERE id='%d'", valueStr, idChannel)
-    } else {
-        // Write status
-        qbuf = fmt.Sprintf("UPDATE channel SET status='%s' WHERE id='%d'", "normal", idChannel)
-        if _, err := sock.Exec(qbuf); err != nil {
-            return 4
-        }
+func makeDatetime(t time.Time) string {
+	switch datetimeFormat {
+	case "iso8601":
+		return t.Format(time.RFC3339)
+	case "iso8601utc":
+		return t.UTC().Format(time.RFC3339)
+	case "default", "":
+		return t.Format("2006-01-02 15:04:05") // MySQL datetime format
+	default:
+		return t.Format(datetimeFormat)
+	}
+}
 
-        // Prepare data insert
-        qbuf = fmt.Sprintf("INSERT INTO data (id_channel, datetime, value) VALUES ('%d','%s','%s')", 
-            idChannel, makeDatetime(t), valueStr)
-    }
 
-    // Execute the final query
-    if _, err := sock.Exec(qbuf); err != nil {
-		slog.Debug("DB", "query", qbuf);
-        return 5
-    }
+// Final scan on shutdown
+var (
+	finalScan       = false
+	finalScanBudget = 10 * time.Second
+)
 
-    return 0
+// shutdown stops the program after an optional final scan
+func shutdown() {
+	if finalScan {
+		runFinalScan()
+	}
+	// The summary would race with the counters of a running scan. The lock
+	// is kept until the exit.
+	idle := lockScan(finalScanBudget)
+	cleanup()
+	if printSummary {
+		if idle {
+			writeSummary(os.Stdout)
+		} else {
+			slog.Warn("scan still running, no summary written")
+		}
+	}
+	os.Exit(0)
 }
 
-// You need to implement this function if it's missing
-func makeDatetime(t time.Time) string {
-    return t.Format("2006-01-02 15:04:05") // MySQL datetime format
+// lockScan takes the scan lock, waiting up to budget for a running scan
+func lockScan(budget time.Duration) bool {
+	deadline := time.Now().Add(budget)
+	for !scanMu.TryLock() {
+		if !time.Now().Before(deadline) {
+			return false
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return true
 }
 
+// runFinalScan scans once more within finalScanBudget. It is skipped in the
+// quiet hours and when a scan is already running. A scan exceeding the
+// budget keeps the scan lock until it ends.
+func runFinalScan() {
+	if inQuietHours(time.Now()) {
+		slog.Info("quiet hours, skipping final scan")
+		return
+	}
+	if !scanMu.TryLock() {
+		slog.Info("scan in progress, skipping final scan")
+		return
+	}
+	slog.Info("final scan before shutdown", "budget", finalScanBudget)
+	done := make(chan error, 1)
+	go func() {
+		defer scanMu.Unlock()
+		done <- scan()
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			slog.Error("final scan failed", "error", err)
+		}
+	case <-time.After(finalScanBudget):
+		slog.Warn("final scan exceeded its time budget", "budget", finalScanBudget)
+	}
+}
 
 func cleanup() {
+	flushAudit()
 	if serialPort != nil {
 		serialPort.Close()
 	}
@@ -0,0 +1,806 @@
+package main
+
+import (
+	"bytes"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/tarm/serial"
+	_ "modernc.org/sqlite"
+)
+
+func TestMain(m *testing.M) {
+	slog.SetDefault(slog.New(slog.NewTextHandler(io.Discard, nil)))
+	os.Exit(m.Run())
+}
+
+// setVar sets a package variable for the duration of a test
+func setVar[T any](t *testing.T, p *T, v T) {
+	t.Helper()
+	old := *p
+	*p = v
+	t.Cleanup(func() { *p = old })
+}
+
+// captureLog sends the log records of a test to a buffer
+func captureLog(t *testing.T) *bytes.Buffer {
+	t.Helper()
+	var buf bytes.Buffer
+	old := slog.Default()
+	slog.SetDefault(slog.New(slog.NewTextHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug})))
+	t.Cleanup(func() { slog.SetDefault(old) })
+	return &buf
+}
+
+// testDB creates an SQLite database file with the given tables and makes
+// it the configured database for the duration of a test
+func testDB(t *testing.T, ddl ...string) *sql.DB {
+	t.Helper()
+	setVar(t, &dbDriver, "sqlite")
+	setVar(t, &db, DBAccessData{Name: filepath.Join(t.TempDir(), "test.db")})
+	sock, err := openDB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { sock.Close() })
+	for _, stmt := range ddl {
+		if _, err := sock.Exec(stmt); err != nil {
+			t.Fatalf("%s: %v", stmt, err)
+		}
+	}
+	return sock
+}
+
+// setAddresses configures the scanned addresses for the duration of a test
+func setAddresses(t *testing.T, adrs ...byte) {
+	t.Helper()
+	var table [MAXNUMADR]byte
+	copy(table[:], adrs)
+	setVar(t, &scanAddress, table)
+	setVar(t, &numAdresses, len(adrs))
+	setVar(t, &adrCounter, 0)
+}
+
+// frame builds a response frame: status, payload, ETX and BCC
+func frame(status byte, payload string) []byte {
+	f := append([]byte{status}, payload...)
+	f = append(f, ETX)
+	var bcc byte
+	for _, b := range f {
+		bcc ^= b
+	}
+	return append(f, bcc)
+}
+
+// sentCommand is a command the fake port received
+type sentCommand struct {
+	adr byte
+	cmd string
+}
+
+// fakePort is a scripted serial port. Each command written is answered
+// with the frame reply returns, nil = no answer. The first byte of the
+// answer arrives delay after the command, the rest gap later. Like the real
+// port, a read waits up to a read timeout for data.
+type fakePort struct {
+	mu      sync.Mutex
+	reply   func(adr byte, cmd string) []byte
+	delay   time.Duration
+	slow    map[string]time.Duration // answer delay of single commands
+	gap     time.Duration
+	noise   []byte // stray bytes readable before any command
+	readErr error  // returned by every read, nil = none
+	sent    []sentCommand
+	pending []byte
+	started bool      // first byte of the pending answer read
+	firstAt time.Time // first byte of the pending answer arrives
+	closed  bool
+}
+
+const fakeReadTimeout = 20 * time.Millisecond
+
+func (p *fakePort) Write(b []byte) (int, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	adr := b[0] - 0x80
+	cmd := string(b[1:bytes.IndexByte(b, ETX)])
+	p.sent = append(p.sent, sentCommand{adr, cmd})
+
+	p.pending = nil
+	if p.reply != nil {
+		p.pending = p.reply(adr, cmd)
+	}
+	p.started = false
+	p.firstAt = time.Now().Add(p.delay)
+	if d, ok := p.slow[cmd]; ok {
+		p.firstAt = time.Now().Add(d)
+	}
+	return len(b), nil
+}
+
+func (p *fakePort) Read(b []byte) (int, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.readErr != nil {
+		time.Sleep(fakeReadTimeout)
+		return 0, p.readErr
+	}
+	if len(p.noise) > 0 {
+		n := copy(b, p.noise)
+		p.noise = p.noise[n:]
+		return n, nil
+	}
+	if len(p.pending) == 0 {
+		time.Sleep(fakeReadTimeout)
+		return 0, nil
+	}
+
+	at, n := p.firstAt, 1
+	if p.started {
+		at, n = p.firstAt.Add(p.gap), len(p.pending)
+	}
+	wait := time.Until(at)
+	if wait > fakeReadTimeout {
+		time.Sleep(fakeReadTimeout)
+		return 0, nil
+	}
+	time.Sleep(wait)
+	n = copy(b[:min(n, len(b))], p.pending)
+	p.pending = p.pending[n:]
+	p.started = true
+	return n, nil
+}
+
+func (p *fakePort) Close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.closed = true
+	return nil
+}
+
+// commands returns the commands sent so far
+func (p *fakePort) commands() []sentCommand {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]sentCommand(nil), p.sent...)
+}
+
+// usePort makes the fake port the open serial port for the duration of a
+// test
+func usePort(t *testing.T, p *fakePort) {
+	t.Helper()
+	setVar(t, &serialPort, &SerialPort{port: p})
+}
+
+// memStore keeps the records written in memory
+type memStore struct {
+	mu   sync.Mutex
+	recs []record
+}
+
+func (s *memStore) write(rec record) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.recs = append(s.recs, rec)
+	return 0
+}
+
+// records returns the records written so far and forgets them
+func (s *memStore) records() []record {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	recs := s.recs
+	s.recs = nil
+	return recs
+}
+
+// scanTest prepares scans of the addresses over the fake port with short
+// timing and one try per command. It returns the store the scans write to.
+// The device state changed by the scans is restored after the test.
+func scanTest(t *testing.T, p *fakePort, adrs ...byte) *memStore {
+	t.Helper()
+	setAddresses(t, adrs...)
+	setVar(t, &openSerial, func(*serial.Config) (io.ReadWriteCloser, error) { return p, nil })
+	setVar(t, &serialPort, nil)
+	setVar(t, &portDevice, "")
+	setVar(t, &serialDeviceStr, "/dev/ttyTEST0")
+	setVar(t, &responseDelayMax, 30*time.Millisecond)
+	setVar(t, &snRetrys, 1)
+	setVar(t, &measureRetrys, 1)
+
+	setVar(t, &lastScan, time.Time{})
+	setVar(t, &scanned, [MAXNUMADR]bool{})
+	setVar(t, &scanNext, 0)
+	setVar(t, &storedValues, 0)
+	setVar(t, &retryCnt, [MAXNUMADR]int{})
+	setVar(t, &serNoStr, [MAXNUMADR]string{})
+	setVar(t, &valueStr, [MAXNUMADR]string{})
+	setVar(t, &timestamp, [MAXNUMADR]time.Time{})
+	setVar(t, &measRetries, [MAXNUMADR]int{})
+	setVar(t, &msgSent, [MAXNUMADR]int64{})
+	setVar(t, &msgReceived, [MAXNUMADR]int64{})
+	setVar(t, &msgNAK, [MAXNUMADR]int64{})
+	setVar(t, &latency, [MAXNUMADR]latencyWindow{})
+	setVar(t, &readDevice, [MAXNUMADR]string{})
+	setVar(t, &lastError, [MAXNUMADR]addrError{})
+	setVar(t, &lastCommand, [MAXNUMADR]string{})
+	setVar(t, &measureOK, [MAXNUMADR]int64{})
+	setVar(t, &measureFail, [MAXNUMADR]int64{})
+	setVar(t, &failedScans, [MAXNUMADR]int{})
+	setVar(t, &resetPending, [MAXNUMADR]bool{})
+	setVar(t, &resetUntil, [MAXNUMADR]time.Time{})
+	setVar(t, &knownSerial, [MAXNUMADR]string{})
+	setVar(t, &previousSerial, [MAXNUMADR]string{})
+	setVar(t, &serialChanges, [MAXNUMADR]int64{})
+	setVar(t, &collisions, [MAXNUMADR]int64{})
+	setVar(t, &extremes, [MAXNUMADR]extreme{})
+	setVar(t, &deviceUnit, [MAXNUMADR]string{})
+	setVar(t, &rawValue, [MAXNUMADR]string{})
+	setVar(t, &valueUnit, [MAXNUMADR]string{})
+	setVar(t, &unitSerial, [MAXNUMADR]string{})
+	setVar(t, &deviceTag, [MAXNUMADR]string{})
+	setVar(t, &tagSerial, [MAXNUMADR]string{})
+	setVar(t, &deviceSettings, [MAXNUMADR]string{})
+	setVar(t, &settingsSerial, [MAXNUMADR]string{})
+	setVar(t, &deviceRuntime, [MAXNUMADR]string{})
+	setVar(t, &runtimeSerial, [MAXNUMADR]string{})
+	setVar(t, &runtimeRead, [MAXNUMADR]time.Time{})
+	setVar(t, &precisionValue, [MAXNUMADR]string{})
+	setVar(t, &precisionTime, [MAXNUMADR]time.Time{})
+	setVar(t, &precisionPending, [MAXNUMADR]bool{})
+	setVar(t, &alarmSamples, [MAXNUMADR][]float64{})
+	setVar(t, &alarmActive, [MAXNUMADR]bool{})
+	setVar(t, &unitChecked, [MAXNUMADR]bool{})
+	setVar(t, &metricsAddrs, nil)
+
+	s := &memStore{}
+	setVar(t, &dataStore, store(s))
+	return s
+}
+
+// answer returns a reply answering each command listed with an ACK frame
+// carrying its payload, and nothing else
+func answer(payloads map[string]string) func(adr byte, cmd string) []byte {
+	return func(adr byte, cmd string) []byte {
+		if payload, ok := payloads[cmd]; ok {
+			return frame(ACK, payload)
+		}
+		return nil
+	}
+}
+
+// provision adds a unit with one channel for each serial number, the
+// channel id being the position in serials plus 1
+func provision(t *testing.T, sock *sql.DB, serials ...string) {
+	t.Helper()
+	for i, serial := range serials {
+		if _, err := sock.Exec("INSERT INTO unit (id, serialnumber) VALUES (?, ?)", i+1, serial); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := sock.Exec("INSERT INTO channel (id, id_unit, status) VALUES (?, ?, '')", i+1, i+1); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// rowCount returns the number of rows of a table
+func rowCount(t *testing.T, sock *sql.DB, table string) int {
+	t.Helper()
+	var n int
+	if err := sock.QueryRow("SELECT count(*) FROM " + table).Scan(&n); err != nil {
+		t.Fatal(err)
+	}
+	return n
+}
+
+// dataColumn returns a column of the data table ordered by channel
+func dataColumn(t *testing.T, sock *sql.DB, column string) []string {
+	t.Helper()
+	rows, err := sock.Query("SELECT " + column + " FROM data ORDER BY id_channel, rowid")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	var values []string
+	for rows.Next() {
+		var v sql.NullString
+		if err := rows.Scan(&v); err != nil {
+			t.Fatal(err)
+		}
+		values = append(values, v.String)
+	}
+	return values
+}
+
+func TestMeasurementTablesByType(t *testing.T) {
+	sock := testDB(t, channelDDL, unitDDL, dataDDL,
+		"CREATE TABLE temps (id_channel integer, datetime timestamp, value real)",
+		"CREATE TABLE humid (id_channel integer, datetime timestamp, value real)")
+	provision(t, sock, "T1", "H1")
+	setVar(t, &dataTables, map[string]string{})
+	setSchemaName("table.temperature", "temps")
+	setSchemaName("table.humidity", "humid")
+
+	now := time.Now()
+	for _, rec := range []record{
+		{Serial: "T1", Value: "21.5", Type: "temperature", Time: now},
+		{Serial: "H1", Value: "45", Type: "humidity", Time: now},
+		{Serial: "H1", Value: "46", Type: "humidity", Time: now},
+		{Serial: "T1", Value: "7", Type: "pressure", Time: now},
+	} {
+		if status := writeToDB(rec); status != 0 {
+			t.Fatalf("writeToDB(%+v) = %d", rec, status)
+		}
+	}
+
+	var value float64
+	if err := sock.QueryRow("SELECT value FROM temps WHERE id_channel = 1").Scan(&value); err != nil || value != 21.5 {
+		t.Errorf("temperature row = %v, %v; want 21.5 in temps", value, err)
+	}
+	if n := rowCount(t, sock, "humid"); n != 2 {
+		t.Errorf("%d humidity rows, want 2", n)
+	}
+	if n := rowCount(t, sock, "data"); n != 1 {
+		t.Errorf("%d rows of the unrouted type in data, want 1", n)
+	}
+}
+
+// loadTestConfig loads a config file with the given lines
+func loadTestConfig(t *testing.T, lines ...string) error {
+	t.Helper()
+	file := filepath.Join(t.TempDir(), "test.cfg")
+	if err := os.WriteFile(file, []byte(strings.Join(lines, "\n")+"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	setVar(t, &configFileName, file)
+	setAddresses(t)
+	setVar(t, &serialDeviceStr, serialDeviceStr)
+	setVar(t, &dataStore, dataStore)
+	return loadConfig()
+}
+
+func TestConfigWithoutValidAddresses(t *testing.T) {
+	err := loadTestConfig(t, `scanAddresses = "abc, 300, x,"`)
+	if err == nil || !strings.Contains(err.Error(), "no valid scan addresses") {
+		t.Errorf("loadConfig = %v, want the no valid scan addresses error", err)
+	}
+	if err := loadTestConfig(t, `SerialDevice = "/dev/ttyS1"`); err == nil {
+		t.Error("loadConfig without scanAddresses succeeded")
+	}
+}
+
+func TestConfigAddresses(t *testing.T) {
+	if err := loadTestConfig(t, `scanAddresses = "1, 2,`, `  x, 17"`); err != nil {
+		t.Fatal(err)
+	}
+	if numAdresses != 3 || scanAddress[0] != 1 || scanAddress[1] != 2 || scanAddress[2] != 17 {
+		t.Errorf("addresses %v, want 1, 2 and 17", scanAddress[:numAdresses])
+	}
+}
+
+// fakeClock is a clock advanced by its sleeps
+type fakeClock struct {
+	now    time.Time
+	sleeps []time.Duration
+}
+
+func (c *fakeClock) Now() time.Time { return c.now }
+
+func (c *fakeClock) Sleep(d time.Duration) {
+	c.sleeps = append(c.sleeps, d)
+	c.now = c.now.Add(d)
+}
+
+func TestWritePacing(t *testing.T) {
+	clock := &fakeClock{now: time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)}
+	setVar(t, &paceNow, clock.Now)
+	setVar(t, &paceSleep, clock.Sleep)
+	setVar(t, &lastDBWrite, time.Time{})
+	setVar(t, &dbWriteRate, 4)
+
+	start := clock.now
+	for i := 0; i < 5; i++ {
+		paceDBWrite()
+	}
+	if got := clock.now.Sub(start); got != time.Second {
+		t.Errorf("5 writes at 4 per second took %v, want 1s", got)
+	}
+	for _, d := range clock.sleeps {
+		if d != 250*time.Millisecond {
+			t.Errorf("slept %v between writes, want 250ms", d)
+		}
+	}
+
+	// Time spent elsewhere counts towards the gap
+	clock.sleeps = nil
+	clock.now = clock.now.Add(100 * time.Millisecond)
+	paceDBWrite()
+	clock.now = clock.now.Add(time.Second)
+	paceDBWrite()
+	if len(clock.sleeps) != 1 || clock.sleeps[0] != 150*time.Millisecond {
+		t.Errorf("sleeps %v, want one of 150ms", clock.sleeps)
+	}
+
+	setVar(t, &dbWriteRate, 0)
+	clock.sleeps = nil
+	paceDBWrite()
+	paceDBWrite()
+	if len(clock.sleeps) != 0 {
+		t.Errorf("unlimited writes slept %v", clock.sleeps)
+	}
+}
+
+func TestStatusOnChange(t *testing.T) {
+	sock := testDB(t, channelDDL, unitDDL, dataDDL,
+		"CREATE TABLE updates (status text)",
+		"CREATE TRIGGER count_updates AFTER UPDATE ON channel BEGIN INSERT INTO updates VALUES (NEW.status); END")
+	provision(t, sock, "S1")
+	setVar(t, &lastStatus, map[int]string{})
+	setVar(t, &statusOnChange, true)
+
+	now := time.Now()
+	for _, value := range []string{"20.1", "20.2", "20.3", "100002", "20.4", "20.5"} {
+		if status := writeToDB(record{Serial: "S1", Value: value, Type: "temperature", Time: now}); status != 0 {
+			t.Fatalf("writeToDB(%s) = %d", value, status)
+		}
+	}
+
+	rows, err := sock.Query("SELECT status FROM updates")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	var updates []string
+	for rows.Next() {
+		var s string
+		rows.Scan(&s)
+		updates = append(updates, s)
+	}
+	if want := []string{"normal", "100002", "normal"}; !slices.Equal(updates, want) {
+		t.Errorf("status updates %q, want %q", updates, want)
+	}
+	if n := rowCount(t, sock, "data"); n != 5 {
+		t.Errorf("%d data rows, want 5", n)
+	}
+}
+
+func TestDatetimeFormats(t *testing.T) {
+	cet := time.FixedZone("CET", 3600)
+	ts := time.Date(2024, 3, 1, 13, 4, 5, 0, cet)
+	tests := []struct {
+		format, want string
+	}{
+		{"default", "2024-03-01 13:04:05"},
+		{"", "2024-03-01 13:04:05"},
+		{"iso8601", "2024-03-01T13:04:05+01:00"},
+		{"iso8601utc", "2024-03-01T12:04:05Z"},
+		{"02.01.2006 15:04", "01.03.2024 13:04"},
+	}
+	for _, tt := range tests {
+		setVar(t, &datetimeFormat, tt.format)
+		if got := makeDatetime(ts); got != tt.want {
+			t.Errorf("format %q: makeDatetime = %q, want %q", tt.format, got, tt.want)
+		}
+	}
+}
+
+func TestQualityMetadata(t *testing.T) {
+	// Address 2 answers its first measurement with a corrupted frame
+	corrupted := false
+	port := &fakePort{reply: func(adr byte, cmd string) []byte {
+		switch cmd {
+		case "SN ?":
+			return frame(ACK, []string{1: "S1", 2: "S2"}[adr])
+		case "MEA CH 1 ?":
+			f := frame(ACK, "21.5")
+			if adr == 2 && !corrupted {
+				corrupted = true
+				f[len(f)-1] ^= 0xff
+			}
+			return f
+		}
+		return nil
+	}}
+	scanTest(t, port, 1, 2)
+	setVar(t, &measureRetrys, 3)
+	sock := testDB(t, channelDDL, unitDDL,
+		"CREATE TABLE data (id_channel integer, datetime timestamp, value real, first_try boolean, retries integer)")
+	provision(t, sock, "S1", "S2")
+	setVar(t, &storeQuality, true)
+	setVar(t, &dataStore, store(dbStore{}))
+
+	if err := scan(); err != nil {
+		t.Fatal(err)
+	}
+
+	for id, want := range map[int]struct {
+		firstTry bool
+		retries  int
+	}{1: {true, 0}, 2: {false, 1}} {
+		var firstTry bool
+		var retries int
+		err := sock.QueryRow("SELECT first_try, retries FROM data WHERE id_channel = ?", id).Scan(&firstTry, &retries)
+		if err != nil {
+			t.Fatalf("channel %d: %v", id, err)
+		}
+		if firstTry != want.firstTry || retries != want.retries {
+			t.Errorf("channel %d stored first_try %v, retries %d; want %v, %d", id, firstTry, retries, want.firstTry, want.retries)
+		}
+	}
+}
+
+func TestFinalScan(t *testing.T) {
+	port := &fakePort{reply: answer(map[string]string{"SN ?": "S1", "MEA CH 1 ?": "19.5"})}
+	stored := scanTest(t, port, 1)
+	setVar(t, &finalScanBudget, 5*time.Second)
+
+	runFinalScan()
+	if !lockScan(time.Second) {
+		t.Fatal("scan lock still held after the final scan")
+	}
+	scanMu.Unlock()
+
+	recs := stored.records()
+	if len(recs) != 1 || recs[0].Serial != "S1" || recs[0].Value != "19.5" {
+		t.Errorf("final scan stored %+v, want S1 with 19.5", recs)
+	}
+}
+
+func TestFinalScanSkippedDuringScan(t *testing.T) {
+	port := &fakePort{reply: answer(map[string]string{"SN ?": "S1", "MEA CH 1 ?": "19.5"})}
+	stored := scanTest(t, port, 1)
+
+	scanMu.Lock()
+	runFinalScan()
+	scanMu.Unlock()
+
+	if cmds := port.commands(); len(cmds) != 0 || len(stored.records()) != 0 {
+		t.Errorf("final scan ran during a scan: sent %v", cmds)
+	}
+}
+
+func TestLockScanWaitsForScan(t *testing.T) {
+	scanMu.Lock()
+	if lockScan(30 * time.Millisecond) {
+		t.Fatal("took the lock of a running scan")
+	}
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		scanMu.Unlock()
+	}()
+	if !lockScan(time.Second) {
+		t.Fatal("scan lock not taken after the scan ended")
+	}
+	scanMu.Unlock()
+}
+
+func TestNonNumericValueRejected(t *testing.T) {
+	// Any database access fails with status 1
+	setVar(t, &dbDriver, "sqlite")
+	setVar(t, &db, DBAccessData{Name: filepath.Join(t.TempDir(), "missing", "test.db")})
+	setVar(t, &valueType, "numeric")
+
+	if status := writeToDB(record{Serial: "S1", Value: "21,5x", Type: "temperature"}); status != 6 {
+		t.Errorf("writeToDB of a non-numeric value = %d, want 6 before any database access", status)
+	}
+	if status := writeToDB(record{Serial: "S1", Value: " 21.5 ", Type: "temperature"}); status != 1 {
+		t.Errorf("writeToDB of a numeric value = %d, want the database to be reached", status)
+	}
+	if status := writeToDB(record{Serial: "S1", Value: "100003", Type: "temperature"}); status != 1 {
+		t.Errorf("writeToDB of a status code = %d, want the database to be reached", status)
+	}
+}
+
+func TestRetriesPerCommand(t *testing.T) {
+	port := &fakePort{}
+	scanTest(t, port, 1)
+	usePort(t, port)
+	setVar(t, &snRetrys, 2)
+	setVar(t, &measureRetrys, 4)
+
+	if err := getSerialNumber(); err == nil {
+		t.Error("getSerialNumber without an answer succeeded")
+	}
+	if err := getMeasurement(); err == nil {
+		t.Error("getMeasurement without an answer succeeded")
+	}
+
+	count := map[string]int{}
+	for _, c := range port.commands() {
+		count[c.cmd]++
+	}
+	if count["SN ?"] != 2 || count["MEA CH 1 ?"] != 4 {
+		t.Errorf("sent %v, want 2 serial number and 4 measurement tries", count)
+	}
+}
+
+func TestFirstScanMode(t *testing.T) {
+	for _, mode := range []string{"flag", "skip", "store"} {
+		t.Run(mode, func(t *testing.T) {
+			port := &fakePort{reply: answer(map[string]string{"SN ?": "S1", "MEA CH 1 ?": "20"})}
+			stored := scanTest(t, port, 1)
+			setVar(t, &firstScanMode, mode)
+
+			var quality [2][]string
+			for i := range quality {
+				scan()
+				for _, rec := range stored.records() {
+					quality[i] = append(quality[i], rec.Quality)
+				}
+			}
+
+			first := map[string][]string{"flag": {"startup"}, "skip": nil, "store": {"normal"}}[mode]
+			if !slices.Equal(quality[0], first) || !slices.Equal(quality[1], []string{"normal"}) {
+				t.Errorf("stored qualities %q, %q; want %q, [normal]", quality[0], quality[1], first)
+			}
+		})
+	}
+}
+
+func TestScanBatchCoverage(t *testing.T) {
+	port := &fakePort{reply: func(adr byte, cmd string) []byte {
+		if cmd == "SN ?" {
+			return frame(ACK, fmt.Sprintf("S%d", adr))
+		}
+		return frame(ACK, "20")
+	}}
+	stored := scanTest(t, port, 1, 2, 3, 4, 5)
+	setVar(t, &maxAddressesPerScan, 2)
+	setVar(t, &firstScanMode, "flag")
+
+	var polled [][]string
+	for i := 0; i < 4; i++ {
+		scan()
+		var got []string
+		for _, rec := range stored.records() {
+			got = append(got, rec.Serial+" "+rec.Quality)
+		}
+		polled = append(polled, got)
+	}
+
+	want := [][]string{
+		{"S1 startup", "S2 startup"},
+		{"S3 startup", "S4 startup"},
+		{"S5 startup", "S1 normal"},
+		{"S2 normal", "S3 normal"},
+	}
+	for i := range want {
+		if !slices.Equal(polled[i], want[i]) {
+			t.Errorf("scan %d stored %q, want %q", i+1, polled[i], want[i])
+		}
+	}
+}
+
+func TestShortPayloadRejected(t *testing.T) {
+	port := &fakePort{reply: answer(map[string]string{"SN ?": "S1", "MEA CH 1 ?": "2"})}
+	scanTest(t, port, 1)
+	usePort(t, port)
+	setVar(t, &minPayloadSN, 2)
+	setVar(t, &minPayloadMeasure, 3)
+
+	if err := getSerialNumber(); err != nil {
+		t.Fatalf("getSerialNumber: %v", err)
+	}
+	if err := getMeasurement(); !errors.Is(err, errShort) {
+		t.Errorf("getMeasurement of a 1 byte payload = %v, want errShort", err)
+	}
+
+	setVar(t, &minPayloadSN, 3)
+	if err := getSerialNumber(); !errors.Is(err, errShort) {
+		t.Errorf("getSerialNumber of a 2 byte payload = %v, want errShort", err)
+	}
+}
+
+func TestLookupRetried(t *testing.T) {
+	sock := testDB(t, channelDDL, unitDDL, dataDDL)
+	provision(t, sock, "S1")
+	rec := record{Serial: "S1", Value: "20.5", Type: "temperature", Time: time.Now()}
+	rename := func(from, to string) {
+		if _, err := sock.Exec("ALTER TABLE " + from + " RENAME TO " + to); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// The channel table is gone as while the database restarts, and comes
+	// back during the third wait
+	var waits []time.Duration
+	setVar(t, &lookupRetryDelay, time.Second)
+	setVar(t, &lookupSleep, func(d time.Duration) {
+		if waits = append(waits, d); len(waits) == 3 {
+			rename("hidden", "channel")
+		}
+	})
+
+	setVar(t, &lookupRetries, 1)
+	rename("channel", "hidden")
+	if status := writeToDB(rec); status != 2 {
+		t.Errorf("writeToDB with the lookup failing on every try = %d, want 2", status)
+	}
+	setVar(t, &lookupRetries, 3)
+	if status := writeToDB(rec); status != 0 {
+		t.Errorf("writeToDB retrying the lookup = %d, want 0", status)
+	}
+	if want := []time.Duration{time.Second, time.Second, 2 * time.Second}; !slices.Equal(waits, want) {
+		t.Errorf("waits %v, want %v", waits, want)
+	}
+	if n := rowCount(t, sock, "data"); n != 1 {
+		t.Errorf("%d rows, want 1", n)
+	}
+
+	// An unknown serial number is not retried
+	waits = nil
+	if status := writeToDB(record{Serial: "S9", Value: "1", Type: "temperature", Time: time.Now()}); status != 3 {
+		t.Errorf("writeToDB of an unknown serial = %d, want 3", status)
+	}
+	if len(waits) != 0 {
+		t.Errorf("unknown serial retried after %v", waits)
+	}
+}
+
+func TestAlternateTerminator(t *testing.T) {
+	setAddresses(t, 5)
+	k := lookupConfigKey("serial.altTerminator")
+	setVar(t, &altTerminator, altTerminator)
+	for value, want := range map[string]int{"0x0d": 13, "3": 3, "": -1} {
+		if err := k.apply(k.key, value); err != nil || altTerminator != want {
+			t.Errorf("serial.altTerminator = %q: %d, %v; want %d", value, altTerminator, err, want)
+		}
+	}
+	if err := k.apply(k.key, "0x100"); err == nil {
+		t.Error("serial.altTerminator = 0x100 accepted")
+	}
+
+	if err := k.apply(k.key, "0x0d"); err != nil {
+		t.Fatal(err)
+	}
+	withCR := func(f []byte) []byte {
+		f[len(f)-2] = 0x0d
+		f[len(f)-1] ^= ETX ^ 0x0d
+		return f
+	}
+	for name, f := range map[string][]byte{"ETX": frame(ACK, "12345"), "CR": withCR(frame(ACK, "12345"))} {
+		usePort(t, &fakePort{reply: func(adr byte, cmd string) []byte { return f }})
+		var resp string
+		status, err := getValue(&resp, "SN ?", 5)
+		if err != nil || status != ACK || resp != "12345" {
+			t.Errorf("frame ending with %s: %d, %q, %v; want ACK, \"12345\", nil", name, status, resp, err)
+		}
+	}
+}
+
+func TestDevicePathOfReading(t *testing.T) {
+	port := &fakePort{reply: answer(map[string]string{"SN ?": "S1", "MEA CH 1 ?": "20"})}
+	scanTest(t, port, 1)
+	var opened []string
+	setVar(t, &openSerial, func(config *serial.Config) (io.ReadWriteCloser, error) {
+		opened = append(opened, config.Name)
+		return port, nil
+	})
+	sock := testDB(t, channelDDL, unitDDL,
+		"CREATE TABLE data (id_channel integer, datetime timestamp, value real, device varchar(64))")
+	provision(t, sock, "S1")
+	setVar(t, &storeDevice, true)
+	setVar(t, &dataStore, store(dbStore{}))
+
+	for _, dev := range []string{"/dev/ttyUSB0", "/dev/ttyUSB1"} {
+		setVar(t, &serialDeviceStr, dev)
+		if err := scan(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got := dataColumn(t, sock, "device"); !slices.Equal(got, opened) || len(got) != 2 {
+		t.Errorf("stored devices %q, opened %q", got, opened)
+	}
+}
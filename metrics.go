@@ -0,0 +1,307 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// addrMetrics is a snapshot of the per address state taken after each scan
+type addrMetrics struct {
+	Address   byte
+	Serial    string
+	Sent      int64
+	Received  int64
+	NAK       int64
+	Latencies int64
+	SumFirst  time.Duration
+	SumFrame  time.Duration
+	First     [len(quantiles)]time.Duration
+	Frame     [len(quantiles)]time.Duration
+	Delay     time.Duration
+	Type      string
+	Value     string
+	Time      time.Time
+	Extreme   extreme
+	Changes   int64
+	Collide   int64
+	Retries   int
+	Unit      string
+	Tag       string
+	Settings  string
+	Alarm     bool
+	Runtime   string
+	Enabled   bool
+	LastError addrError
+}
+
+var quantiles = [...]float64{0.5, 0.9, 0.99}
+
+var (
+	metricsMu    sync.Mutex
+	metricsAddrs []addrMetrics
+	metricsReset time.Time
+)
+
+// publishMetrics copies the current device state for the metrics endpoint.
+// It is called from the scan loop so the HTTP handler never reads the
+// device state while a scan is updating it.
+func publishMetrics() {
+	snap := make([]addrMetrics, numAdresses)
+	for i := 0; i < numAdresses; i++ {
+		w := &latency[i]
+		m := &snap[i]
+		m.Address = scanAddress[i]
+		m.Serial = serNoStr[i]
+		m.Sent = sentBase[i] + msgSent[i]
+		m.Received = receivedBase[i] + msgReceived[i]
+		m.NAK = nakBase[i] + msgNAK[i]
+		m.Latencies = w.count
+		m.SumFirst = w.sumFirst
+		m.SumFrame = w.sumFrame
+		for q, quantile := range quantiles {
+			m.First[q] = w.percentile(&w.firstByte, quantile)
+			m.Frame[q] = w.percentile(&w.frame, quantile)
+		}
+		m.Delay = responseDelay(i)
+		m.Type = measureType(i)
+		m.Value = valueStr[i]
+		m.Time = timestamp[i]
+		m.Extreme = extremes[i]
+		m.Changes = serialChanges[i]
+		m.Collide = collisions[i]
+		m.Retries = measRetries[i]
+		m.Unit = valueUnit[i]
+		m.Tag = deviceTag[i]
+		m.Settings = deviceSettings[i]
+		m.Alarm = alarmActive[i]
+		m.Runtime = deviceRuntime[i]
+		m.Enabled = addressEnabled(i)
+		m.LastError = lastError[i]
+	}
+
+	metricsMu.Lock()
+	metricsAddrs = snap
+	metricsReset = lastCounterReset
+	metricsMu.Unlock()
+}
+
+func startMetricsServer(addr string) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", handleMetrics)
+	mux.HandleFunc("/status", handleStatus)
+
+	go func() {
+		if err := http.ListenAndServe(addr, mux); err != nil {
+			slog.Error("metrics server failed", "listen", addr, "error", err)
+		}
+	}()
+}
+
+// labels returns the address and measurement type labels of a metric. The
+// types come from the config, which keeps the label cardinality bounded.
+func (m *addrMetrics) labels() string {
+	return fmt.Sprintf("address=\"%d\",type=%q", m.Address, m.Type)
+}
+
+// handleMetrics writes the snapshot in the Prometheus text format
+func handleMetrics(w http.ResponseWriter, r *http.Request) {
+	metricsMu.Lock()
+	snap := metricsAddrs
+	reset := metricsReset
+	metricsMu.Unlock()
+
+	var b strings.Builder
+
+	if exposeBuildInfo {
+		fmt.Fprintf(&b, "# HELP sensor_build_info Build of the running collector, always 1.\n# TYPE sensor_build_info gauge\n")
+		fmt.Fprintf(&b, "sensor_build_info{version=%q,commit=%q,goversion=%q,start_time=\"%d\"} 1\n",
+			version, buildCommit(), runtime.Version(), startTime.Unix())
+	}
+
+	counter := func(name, help string, value func(m *addrMetrics) int64) {
+		fmt.Fprintf(&b, "# HELP %s %s\n# TYPE %s counter\n", name, help, name)
+		for i := range snap {
+			fmt.Fprintf(&b, "%s{address=\"%d\"} %d\n", name, snap[i].Address, value(&snap[i]))
+		}
+	}
+	counter("sensor_messages_sent_total", "Commands sent to the device.",
+		func(m *addrMetrics) int64 { return m.Sent })
+	counter("sensor_messages_received_total", "Valid frames received from the device.",
+		func(m *addrMetrics) int64 { return m.Received })
+	counter("sensor_messages_nak_total", "NAK responses received from the device.",
+		func(m *addrMetrics) int64 { return m.NAK })
+	counter("sensor_serial_changes_total", "Serial number changes seen on the address.",
+		func(m *addrMetrics) int64 { return m.Changes })
+	counter("sensor_address_collisions_total", "Serial numbers alternating on the address.",
+		func(m *addrMetrics) int64 { return m.Collide })
+
+	summary := func(name, help string, sum func(m *addrMetrics) time.Duration, values func(m *addrMetrics) []time.Duration) {
+		fmt.Fprintf(&b, "# HELP %s %s\n# TYPE %s summary\n", name, help, name)
+		for i := range snap {
+			m := &snap[i]
+			for q, v := range values(m) {
+				fmt.Fprintf(&b, "%s{%s,quantile=\"%g\"} %g\n", name, m.labels(), quantiles[q], v.Seconds())
+			}
+			fmt.Fprintf(&b, "%s_sum{%s} %g\n", name, m.labels(), sum(m).Seconds())
+			fmt.Fprintf(&b, "%s_count{%s} %d\n", name, m.labels(), m.Latencies)
+		}
+	}
+	summary("sensor_response_first_byte_seconds", "Time from command write to the first response byte.",
+		func(m *addrMetrics) time.Duration { return m.SumFirst },
+		func(m *addrMetrics) []time.Duration { return m.First[:] })
+	summary("sensor_response_frame_seconds", "Time from command write to the complete response frame.",
+		func(m *addrMetrics) time.Duration { return m.SumFrame },
+		func(m *addrMetrics) []time.Duration { return m.Frame[:] })
+
+	fmt.Fprintf(&b, "# HELP sensor_scans_total Completed scan cycles.\n# TYPE sensor_scans_total counter\nsensor_scans_total %d\n", scansDone.Load())
+	fmt.Fprintf(&b, "# HELP sensor_bus_noise_bytes_total Stray bytes read from the idle bus.\n# TYPE sensor_bus_noise_bytes_total counter\nsensor_bus_noise_bytes_total %d\n", noiseBytes.Load())
+	fmt.Fprintf(&b, "# HELP sensor_scan_slots_skipped_total Aligned scan slots skipped after an overrun.\n# TYPE sensor_scan_slots_skipped_total counter\nsensor_scan_slots_skipped_total %d\n", skippedSlots.Load())
+	fmt.Fprintf(&b, "# HELP sensor_values_rejected_total Values failing their validator.\n# TYPE sensor_values_rejected_total counter\nsensor_values_rejected_total %d\n", rejectedValues.Load())
+	fmt.Fprintf(&b, "# HELP sensor_port_open_failures Consecutive failures to open the serial port.\n# TYPE sensor_port_open_failures gauge\nsensor_port_open_failures %d\n", openFailures.Load())
+	degraded := 0
+	if portDegraded.Load() {
+		degraded = 1
+	}
+	fmt.Fprintf(&b, "# HELP sensor_port_degraded 1 while the serial port is degraded after open failures.\n# TYPE sensor_port_degraded gauge\nsensor_port_degraded %d\n", degraded)
+	fmt.Fprintf(&b, "# HELP sensor_heartbeats_total Heartbeats emitted.\n# TYPE sensor_heartbeats_total counter\nsensor_heartbeats_total %d\n", heartbeats.Load())
+
+	if !reset.IsZero() {
+		fmt.Fprintf(&b, "# HELP sensor_counters_reset_timestamp_seconds Time of the last daily counter reset.\n# TYPE sensor_counters_reset_timestamp_seconds gauge\nsensor_counters_reset_timestamp_seconds %d\n", reset.Unix())
+	}
+
+	fmt.Fprintf(&b, "# HELP sensor_response_delay_seconds Current response wait per address.\n# TYPE sensor_response_delay_seconds gauge\n")
+	for i := range snap {
+		fmt.Fprintf(&b, "sensor_response_delay_seconds{address=\"%d\"} %g\n", snap[i].Address, snap[i].Delay.Seconds())
+	}
+
+	fmt.Fprintf(&b, "# HELP sensor_value Last measured value.\n# TYPE sensor_value gauge\n")
+	for i := range snap {
+		if v, err := strconv.ParseFloat(strings.TrimSpace(snap[i].Value), 64); err == nil && !isStatusCode(snap[i].Value) {
+			fmt.Fprintf(&b, "sensor_value{%s} %g\n", snap[i].labels(), v)
+		}
+	}
+	fmt.Fprintf(&b, "# HELP sensor_measurement_retries Retries needed by the last measurement.\n# TYPE sensor_measurement_retries gauge\n")
+	for i := range snap {
+		fmt.Fprintf(&b, "sensor_measurement_retries{%s} %d\n", snap[i].labels(), snap[i].Retries)
+	}
+
+	fmt.Fprintf(&b, "# HELP sensor_device_runtime_hours Operating hours reported by the device.\n# TYPE sensor_device_runtime_hours gauge\n")
+	for i := range snap {
+		if v, err := strconv.ParseFloat(snap[i].Runtime, 64); err == nil {
+			fmt.Fprintf(&b, "sensor_device_runtime_hours{address=\"%d\"} %g\n", snap[i].Address, v)
+		}
+	}
+
+	fmt.Fprintf(&b, "# HELP sensor_address_enabled 1 while the address is polled, 0 while disabled.\n# TYPE sensor_address_enabled gauge\n")
+	for i := range snap {
+		enabled := 0
+		if snap[i].Enabled {
+			enabled = 1
+		}
+		fmt.Fprintf(&b, "sensor_address_enabled{address=\"%d\"} %d\n", snap[i].Address, enabled)
+	}
+
+	if exposeLastError {
+		fmt.Fprintf(&b, "# HELP sensor_last_error_timestamp_seconds Time of the last error per address, cleared by a successful measurement.\n# TYPE sensor_last_error_timestamp_seconds gauge\n")
+		for i := range snap {
+			if e := &snap[i].LastError; !e.Time.IsZero() {
+				fmt.Fprintf(&b, "sensor_last_error_timestamp_seconds{%s,class=%q} %d\n", snap[i].labels(), e.Class, e.Time.Unix())
+			}
+		}
+	}
+
+	fmt.Fprintf(&b, "# HELP sensor_alarm 1 while the window average is outside the alarm limits.\n# TYPE sensor_alarm gauge\n")
+	for i := range snap {
+		alarm := 0
+		if snap[i].Alarm {
+			alarm = 1
+		}
+		fmt.Fprintf(&b, "sensor_alarm{%s} %d\n", snap[i].labels(), alarm)
+	}
+
+	gauge := func(name, help string, value func(e *extreme) float64) {
+		fmt.Fprintf(&b, "# HELP %s %s\n# TYPE %s gauge\n", name, help, name)
+		for i := range snap {
+			if e := &snap[i].Extreme; e.Valid {
+				fmt.Fprintf(&b, "%s{%s} %g\n", name, snap[i].labels(), value(e))
+			}
+		}
+	}
+	gauge("sensor_value_min", "Lowest value in the current extremes interval.",
+		func(e *extreme) float64 { return e.Min })
+	gauge("sensor_value_min_timestamp_seconds", "Time the lowest value was measured.",
+		func(e *extreme) float64 { return float64(e.MinAt.Unix()) })
+	gauge("sensor_value_max", "Highest value in the current extremes interval.",
+		func(e *extreme) float64 { return e.Max })
+	gauge("sensor_value_max_timestamp_seconds", "Time the highest value was measured.",
+		func(e *extreme) float64 { return float64(e.MaxAt.Unix()) })
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	w.Write([]byte(b.String()))
+}
+
+// jsonFields renames the JSON payload fields, set with "json.field.<name>"
+// entries so the output can match an existing schema.
+var jsonFields = make(map[string]string)
+
+// jsonField returns the configured name of a JSON payload field
+func jsonField(name string) string {
+	if alias, ok := jsonFields[name]; ok {
+		return alias
+	}
+	return name
+}
+
+// handleStatus writes the snapshot as JSON, one entry per address
+func handleStatus(w http.ResponseWriter, r *http.Request) {
+	metricsMu.Lock()
+	snap := metricsAddrs
+	metricsMu.Unlock()
+
+	status := make([]map[string]any, len(snap))
+	for i := range snap {
+		m := &snap[i]
+		status[i] = map[string]any{
+			jsonField("address"):   m.Address,
+			jsonField("serial"):    m.Serial,
+			jsonField("type"):      m.Type,
+			jsonField("value"):     m.Value,
+			jsonField("unit"):      m.Unit,
+			jsonField("tag"):       m.Tag,
+			jsonField("settings"):  m.Settings,
+			jsonField("alarm"):     m.Alarm,
+			jsonField("runtime"):   m.Runtime,
+			jsonField("enabled"):   m.Enabled,
+			jsonField("timestamp"): m.Time,
+		}
+		if e := &m.LastError; exposeLastError && !e.Time.IsZero() {
+			status[i][jsonField("lastError")] = map[string]any{
+				jsonField("class"):   e.Class,
+				jsonField("message"): e.Message,
+				jsonField("time"):    e.Time,
+				jsonField("command"): e.Command,
+			}
+		}
+		if e := &m.Extreme; e.Valid {
+			status[i][jsonField("extremes")] = map[string]any{
+				jsonField("min"):   e.Min,
+				jsonField("minAt"): e.MinAt,
+				jsonField("max"):   e.Max,
+				jsonField("maxAt"): e.MaxAt,
+				jsonField("since"): e.Since,
+			}
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(status); err != nil {
+		slog.Error("status encoding failed", "error", err)
+	}
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http/httptest"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+// getStatus returns the decoded /status output
+func getStatus(t *testing.T) []map[string]any {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	handleStatus(rec, httptest.NewRequest("GET", "/status", nil))
+	var status []map[string]any
+	if err := json.Unmarshal(rec.Body.Bytes(), &status); err != nil {
+		t.Fatalf("decoding %q: %v", rec.Body, err)
+	}
+	return status
+}
+
+func TestJSONFieldNames(t *testing.T) {
+	setVar(t, &jsonFields, map[string]string{"serial": "sn", "value": "reading", "timestamp": "ts"})
+	setVar(t, &metricsAddrs, []addrMetrics{{Address: 3, Serial: "S3", Type: "temperature", Value: "21.5",
+		Time: time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)}})
+
+	status := getStatus(t)
+	if len(status) != 1 {
+		t.Fatalf("%d status entries, want 1", len(status))
+	}
+	got := status[0]
+	for key, want := range map[string]any{"sn": "S3", "reading": "21.5", "ts": "2024-03-01T12:00:00Z", "address": 3.0, "type": "temperature"} {
+		if got[key] != want {
+			t.Errorf("%s = %v, want %v", key, got[key], want)
+		}
+	}
+	for _, key := range []string{"serial", "value", "timestamp"} {
+		if _, ok := got[key]; ok {
+			t.Errorf("renamed field %s still present", key)
+		}
+	}
+}
+
+// getMetrics returns the /metrics output
+func getMetrics(t *testing.T) string {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	handleMetrics(rec, httptest.NewRequest("GET", "/metrics", nil))
+	return rec.Body.String()
+}
+
+func TestMetricsPerMeasurementType(t *testing.T) {
+	setVar(t, &metricsAddrs, []addrMetrics{
+		{Address: 1, Type: "temperature", Value: "21.5", Retries: 2},
+		{Address: 2, Type: "humidity", Value: "45"},
+	})
+
+	out := getMetrics(t)
+	for _, want := range []string{
+		`sensor_value{address="1",type="temperature"} 21.5`,
+		`sensor_value{address="2",type="humidity"} 45`,
+		`sensor_measurement_retries{address="1",type="temperature"} 2`,
+		`sensor_measurement_retries{address="2",type="humidity"} 0`,
+		`sensor_response_frame_seconds_count{address="1",type="temperature"} 0`,
+		`sensor_response_frame_seconds_count{address="2",type="humidity"} 0`,
+	} {
+		if !strings.Contains(out, want+"\n") {
+			t.Errorf("metrics lack %s", want)
+		}
+	}
+}
+
+func TestBuildInfo(t *testing.T) {
+	setVar(t, &metricsAddrs, nil)
+	setVar(t, &version, "1.2.3")
+	setVar(t, &commit, "abc123")
+	setVar(t, &startTime, time.Unix(1700000000, 0))
+
+	setVar(t, &exposeBuildInfo, true)
+	want := fmt.Sprintf(`sensor_build_info{version="1.2.3",commit="abc123",goversion=%q,start_time="1700000000"} 1`,
+		runtime.Version())
+	if out := getMetrics(t); !strings.Contains(out, want+"\n") || !strings.Contains(out, "# TYPE sensor_build_info gauge") {
+		t.Errorf("metrics without %s:\n%s", want, out)
+	}
+
+	setVar(t, &exposeBuildInfo, false)
+	if out := getMetrics(t); strings.Contains(out, "sensor_build_info") {
+		t.Errorf("sensor_build_info exported while disabled:\n%s", out)
+	}
+
+	var buf bytes.Buffer
+	printVersion(&buf)
+	if want := "tempreg 1.2.3 (commit abc123, " + runtime.Version() + ")\n"; buf.String() != want {
+		t.Errorf("-version printed %q, want %q", buf.String(), want)
+	}
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"encoding/hex"
+	"io"
+	"log/slog"
+	"sync/atomic"
+	"time"
+)
+
+const NOISE_SAMPLE_LEN = 32 // bytes of stray data shown in the report
+
+var noiseSample time.Duration // 0 = no bus noise sampling
+
+var noiseBytes atomic.Int64
+
+// ReadIdle reads the bus for d without sending anything and returns every
+// byte that arrived. On a quiet bus the result is empty.
+func (sp *SerialPort) ReadIdle(d time.Duration) ([]byte, error) {
+	var stray []byte
+	buf := make([]byte, RXBUFFLEN)
+
+	for end := time.Now().Add(d); time.Now().Before(end); {
+		n, err := sp.port.Read(buf)
+		stray = append(stray, buf[:n]...)
+		if err != nil && err != io.EOF {
+			return stray, err
+		}
+	}
+	return stray, nil
+}
+
+// sampleBusNoise listens to the idle bus and reports any stray bytes, which
+// point at electrical noise or a device talking out of turn.
+func sampleBusNoise(d time.Duration) {
+	stray, err := serialPort.ReadIdle(d)
+	if err != nil {
+		slog.Error("bus noise sampling failed", "error", err)
+	}
+	noiseBytes.Add(int64(len(stray)))
+
+	if len(stray) == 0 {
+		slog.Info("bus idle noise", "duration", d, "bytes", 0)
+		return
+	}
+	sample := stray
+	if len(sample) > NOISE_SAMPLE_LEN {
+		sample = sample[:NOISE_SAMPLE_LEN]
+	}
+	slog.Warn("bus idle noise", "duration", d, "bytes", len(stray), "sample", hex.EncodeToString(sample))
+}
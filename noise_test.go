@@ -0,0 +1,40 @@
+package main
+
+import (
+	"encoding/hex"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBusNoiseReported(t *testing.T) {
+	stray := []byte{0x55, 0xaa, 0x00, 0xff, 0x13}
+	usePort(t, &fakePort{noise: stray})
+	log := captureLog(t)
+	before := noiseBytes.Load()
+
+	sampleBusNoise(50 * time.Millisecond)
+
+	if n := noiseBytes.Load() - before; n != int64(len(stray)) {
+		t.Errorf("counted %d noise bytes, want %d", n, len(stray))
+	}
+	out := log.String()
+	if !strings.Contains(out, "level=WARN msg=\"bus idle noise\"") ||
+		!strings.Contains(out, "bytes=5") || !strings.Contains(out, "sample="+hex.EncodeToString(stray)) {
+		t.Errorf("noise report %q, want a warning with the byte count and hex sample", out)
+	}
+}
+
+func TestQuietBusNoNoise(t *testing.T) {
+	usePort(t, &fakePort{})
+	log := captureLog(t)
+
+	stray, err := serialPort.ReadIdle(30 * time.Millisecond)
+	if err != nil || len(stray) != 0 {
+		t.Fatalf("ReadIdle on a quiet bus = %x, %v; want nothing", stray, err)
+	}
+	sampleBusNoise(30 * time.Millisecond)
+	if out := log.String(); !strings.Contains(out, "level=INFO") || !strings.Contains(out, "bytes=0") {
+		t.Errorf("noise report %q, want an info record with 0 bytes", out)
+	}
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"log/slog"
+	"time"
+)
+
+// High precision measurements, a slow measurement mode some devices offer
+// on demand. It runs at its own, lower cadence with a longer response
+// wait and is stored under its own measurement type.
+var (
+	precisionCommand  []string // "" = no high precision measurements
+	precisionTimeout  = 5 * time.Second
+	precisionInterval = time.Hour
+	precisionType     = "precision"
+)
+
+// High precision measurement state per address
+var (
+	precisionValue   [MAXNUMADR]string
+	precisionTime    [MAXNUMADR]time.Time // time of the last attempt
+	precisionPending [MAXNUMADR]bool      // result not stored yet
+
+	delayOverride time.Duration // response wait replacing responseDelay, 0 = none
+)
+
+// precisionDue reports whether the address at index idx is due for a high
+// precision measurement
+func precisionDue(idx int) bool {
+	return len(precisionCommand) > 0 && (precisionTime[idx].IsZero() || time.Since(precisionTime[idx]) >= precisionInterval)
+}
+
+// getPrecision runs the high precision measurement of the address at index
+// idx with the longer response wait. A failed attempt is not repeated
+// before the next interval.
+func getPrecision(idx int) {
+	adr := scanAddress[idx]
+	precisionTime[idx] = time.Now()
+
+	delayOverride = precisionTimeout
+	var value string
+	status, err := runSequence(&value, precisionCommand, adr)
+	delayOverride = 0
+
+	if err != nil || status != ACK {
+		slog.Debug("high precision measurement failed", "address", adr, "status", status, "error", err)
+		return
+	}
+	precisionValue[idx] = value
+	precisionTime[idx] = time.Now()
+	precisionPending[idx] = true
+	slog.Debug("high precision measurement", "address", adr, "serial", serNoStr[idx], "value", value)
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPrecisionMeasurement(t *testing.T) {
+	port := &fakePort{
+		reply: answer(map[string]string{"SN ?": "S1", "MEA CH 1 ?": "20.1", "MEA PREC ?": "20.1234"}),
+		slow:  map[string]time.Duration{"MEA PREC ?": 150 * time.Millisecond},
+	}
+	stored := scanTest(t, port, 1)
+	setVar(t, &precisionCommand, []string{"MEA PREC ?"})
+	setVar(t, &precisionTimeout, time.Second)
+	setVar(t, &precisionInterval, time.Hour)
+	setVar(t, &precisionType, "precise")
+
+	// The normal response wait is too short for the slow command
+	usePort(t, port)
+	var resp string
+	if status, err := getValue(&resp, "MEA PREC ?", 1); err == nil && status == ACK {
+		t.Fatalf("slow command answered within the normal response wait of %v", responseDelayMax)
+	}
+
+	scan()
+	recs := stored.records()
+	if len(recs) != 2 {
+		t.Fatalf("stored %d records, want the measurement and the high precision one", len(recs))
+	}
+	if r := recs[1]; r.Type != "precise" || r.Value != "20.1234" {
+		t.Errorf("high precision record %q %q, want precise 20.1234", r.Type, r.Value)
+	}
+
+	// Not due again before the interval
+	scan()
+	if recs := stored.records(); len(recs) != 1 || recs[0].Type == "precise" {
+		t.Errorf("second scan stored %+v, want the measurement only", recs)
+	}
+}
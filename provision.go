@@ -0,0 +1,97 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"log/slog"
+)
+
+// Provisioning check. With checkUnitsAtStart, every configured address is
+// looked up in the database: addresses with an expected serial from
+// "address.serial.<adr>" at startup, the others once their serial number
+// has been read. Addresses whose unit has no channel are reported, since
+// their measurements would be dropped.
+var (
+	checkUnitsAtStart = false
+	addressSerials    = make(map[byte]string)
+	unitChecked       [MAXNUMADR]bool
+)
+
+// channelQuery returns the query of the channel ID of a serial number
+func channelQuery() string {
+	return fmt.Sprintf("SELECT %s FROM %s LEFT JOIN %s ON %s = %s WHERE %s = %s",
+		qcol("channel.id"), tbl("channel"), tbl("unit"), qcol("channel.id_unit"), qcol("unit.id"),
+		qcol("unit.serialnumber"), placeholder(1))
+}
+
+// unitProvisioned reports whether a channel of the unit with the serial
+// number exists
+func unitProvisioned(sock *sql.DB, serial string) (bool, error) {
+	var idChannel int
+	err := sock.QueryRow(channelQuery(), serial).Scan(&idChannel)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// checkConfiguredUnits checks the addresses with an expected serial number
+func checkConfiguredUnits() error {
+	if len(addressSerials) == 0 {
+		return nil
+	}
+	sock, err := openDB()
+	if err != nil {
+		return err
+	}
+	defer sock.Close()
+
+	for i := 0; i < numAdresses; i++ {
+		serial, ok := addressSerials[scanAddress[i]]
+		if !ok {
+			continue
+		}
+		known, err := unitProvisioned(sock, serial)
+		if err != nil {
+			return err
+		}
+		if !known {
+			slog.Warn("address has no unit in the database", "address", scanAddress[i], "serial", serial)
+		}
+	}
+	return nil
+}
+
+// checkScannedUnit checks the address at index idx the first time its
+// serial number is known. An expected serial number was checked at startup
+// already, a different one is reported.
+func checkScannedUnit(idx int) {
+	serial := serNoStr[idx]
+	if !checkUnitsAtStart || serial == "" || unitChecked[idx] {
+		return
+	}
+	unitChecked[idx] = true
+
+	adr := scanAddress[idx]
+	if expected, ok := addressSerials[adr]; ok {
+		if expected == serial {
+			return
+		}
+		slog.Warn("unexpected serial number on address", "address", adr, "serial", serial, "expected", expected)
+	}
+
+	sock, err := openDB()
+	if err != nil {
+		slog.Error("unit check failed", "address", adr, "error", err)
+		return
+	}
+	defer sock.Close()
+	known, err := unitProvisioned(sock, serial)
+	if err != nil {
+		slog.Error("unit check failed", "address", adr, "error", err)
+		return
+	}
+	if !known {
+		slog.Warn("address has no unit in the database", "address", adr, "serial", serial)
+	}
+}
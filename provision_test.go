@@ -0,0 +1,60 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestConfiguredUnitMissing(t *testing.T) {
+	sock := testDB(t, channelDDL, unitDDL, dataDDL)
+	provision(t, sock, "S1")
+	setAddresses(t, 1, 2, 3)
+	setVar(t, &addressSerials, map[byte]string{1: "S1", 2: "S2"})
+	log := captureLog(t)
+
+	if err := checkConfiguredUnits(); err != nil {
+		t.Fatal(err)
+	}
+	out := log.String()
+	if n := strings.Count(out, "address has no unit in the database"); n != 1 || !strings.Contains(out, "serial=S2") {
+		t.Errorf("log %q, want one report of S2", out)
+	}
+}
+
+func TestConfiguredUnitCheckError(t *testing.T) {
+	testDB(t) // no tables
+	setAddresses(t, 1)
+	setVar(t, &addressSerials, map[byte]string{1: "S1"})
+	if err := checkConfiguredUnits(); err == nil {
+		t.Error("checkConfiguredUnits without tables succeeded")
+	}
+}
+
+func TestScannedUnitMissing(t *testing.T) {
+	port := &fakePort{reply: func(adr byte, cmd string) []byte {
+		switch cmd {
+		case "SN ?":
+			return frame(ACK, []string{1: "S1", 2: "S9", 3: "S3"}[adr])
+		case "MEA CH 1 ?":
+			return frame(ACK, "20")
+		}
+		return nil
+	}}
+	scanTest(t, port, 1, 2, 3)
+	sock := testDB(t, channelDDL, unitDDL, dataDDL)
+	provision(t, sock, "S1", "S2")
+	setVar(t, &checkUnitsAtStart, true)
+	setVar(t, &addressSerials, map[byte]string{2: "S2"})
+	log := captureLog(t)
+
+	scan()
+	scan()
+	out := log.String()
+	if n := strings.Count(out, "unexpected serial number on address"); n != 1 || !strings.Contains(out, "expected=S2") {
+		t.Errorf("log %q, want S9 reported once as unexpected", out)
+	}
+	if n := strings.Count(out, "address has no unit in the database"); n != 2 ||
+		!strings.Contains(out, "serial=S9") || !strings.Contains(out, "serial=S3") {
+		t.Errorf("log %q, want S9 and S3 reported once each", out)
+	}
+}
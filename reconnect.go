@@ -0,0 +1,139 @@
+package main
+
+import (
+	"errors"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	RECONNECT_MIN_BACKOFF = time.Second
+	RECONNECT_MAX_BACKOFF = 30 * time.Second
+)
+
+// Disconnect detection configuration
+var (
+	disconnectErrors    = 5    // consecutive I/O errors meaning the adapter is gone
+	serialDevicePattern string // glob searched when the device path vanished
+)
+
+var portIOErrors int
+
+var reconnectSleep = time.Sleep // backoff between reconnect attempts, replaced by the tests
+
+var portOpenDelay time.Duration // wait after opening the port before the first command
+
+// Port open failure handling. After openFailureLimit consecutive failures
+// the policy applies: "retry" keeps retrying, "degraded" also marks the
+// port degraded in the metrics, "exit" exits for a supervisor to restart.
+var (
+	openFailureLimit  = 5
+	openFailurePolicy = "retry"
+)
+
+var (
+	openFailures atomic.Int64
+	openRetryAt  time.Time // no open attempt before
+	portDegraded atomic.Bool
+)
+
+// portOpenFailed counts a failed open of the port at the start of a scan,
+// applies the open failure policy and backs off the next attempt.
+func portOpenFailed(err error) {
+	n := openFailures.Add(1)
+	slog.Error("failed to open port", "device", serialDeviceStr, "failures", n, "error", err)
+
+	if n >= int64(openFailureLimit) {
+		switch openFailurePolicy {
+		case "degraded":
+			if !portDegraded.Swap(true) {
+				slog.Error("serial port degraded", "device", serialDeviceStr, "failures", n)
+			}
+		case "exit":
+			slog.Error("exiting after port open failures", "device", serialDeviceStr, "failures", n)
+			cleanup()
+			os.Exit(1)
+		}
+	}
+
+	backoff := RECONNECT_MAX_BACKOFF
+	if n < 6 {
+		backoff = min(RECONNECT_MIN_BACKOFF<<(n-1), RECONNECT_MAX_BACKOFF)
+	}
+	openRetryAt = time.Now().Add(backoff)
+}
+
+// portOpened resets the open failure count after a successful open
+func portOpened() {
+	if n := openFailures.Swap(0); n > 0 {
+		slog.Info("serial port opened", "device", serialDeviceStr, "failures", n)
+	}
+	portDegraded.Store(false)
+	openRetryAt = time.Time{}
+}
+
+// isPortIOError reports whether err came from the operating system rather
+// than from the protocol, e.g. EIO after a USB adapter was unplugged.
+// Read timeouts and checksum errors are not I/O errors.
+func isPortIOError(err error) bool {
+	var pathErr *os.PathError
+	return errors.As(err, &pathErr) && !errors.Is(err, os.ErrDeadlineExceeded)
+}
+
+// checkPortError counts consecutive I/O errors and reacquires the port once
+// they indicate the device is gone. A nil err resets the count.
+func checkPortError(err error) {
+	if err == nil || !isPortIOError(err) {
+		if err == nil {
+			portIOErrors = 0
+		}
+		return
+	}
+
+	portIOErrors++
+	if portIOErrors >= disconnectErrors {
+		reconnectPort(err)
+	}
+}
+
+// reconnectPort closes the failed port and reopens the configured device,
+// or a device matching serialDevicePattern, with growing backoff until one
+// is available again. The systemd watchdog is kept alive meanwhile, so a
+// long unplug does not get the daemon killed.
+func reconnectPort(cause error) {
+	slog.Error("serial port disconnected", "device", serialDeviceStr, "errors", portIOErrors, "error", cause)
+	serialPort.Close()
+
+	start := time.Now()
+	backoff := RECONNECT_MIN_BACKOFF
+	for {
+		reconnectSleep(backoff)
+		sdWatchdog()
+		for _, dev := range serialCandidates() {
+			if err := openPort(dev); err == nil {
+				slog.Info("serial port reconnected", "device", dev, "downtime", time.Since(start))
+				portIOErrors = 0
+				time.Sleep(portOpenDelay)
+				return
+			}
+		}
+		slog.Debug("serial port still unavailable", "device", serialDeviceStr, "retryIn", backoff)
+		if backoff *= 2; backoff > RECONNECT_MAX_BACKOFF {
+			backoff = RECONNECT_MAX_BACKOFF
+		}
+	}
+}
+
+// serialCandidates lists the device paths to try when reconnecting
+func serialCandidates() []string {
+	devs := []string{serialDeviceStr}
+	if serialDevicePattern != "" {
+		if matches, err := filepath.Glob(serialDevicePattern); err == nil {
+			devs = append(devs, matches...)
+		}
+	}
+	return devs
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/tarm/serial"
+)
+
+func TestDisconnectReconnect(t *testing.T) {
+	unplugged := &fakePort{readErr: &os.PathError{Op: "read", Path: "/dev/ttyUSB0", Err: syscall.EIO}}
+	replugged := &fakePort{reply: answer(map[string]string{"SN ?": "S1"})}
+	scanTest(t, unplugged, 1)
+	usePort(t, unplugged)
+
+	// The adapter comes back under a new name
+	dir := t.TempDir()
+	newPath := filepath.Join(dir, "ttyUSB1")
+	if err := os.WriteFile(newPath, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	setVar(t, &serialDeviceStr, "/dev/ttyUSB0")
+	setVar(t, &serialDevicePattern, filepath.Join(dir, "ttyUSB*"))
+	setVar(t, &openSerial, func(config *serial.Config) (io.ReadWriteCloser, error) {
+		if config.Name == newPath {
+			return replugged, nil
+		}
+		return nil, errors.New("no such device")
+	})
+	setVar(t, &disconnectErrors, 2)
+	setVar(t, &portIOErrors, 0)
+
+	var resp string
+	for i := 0; i < 2; i++ {
+		if _, err := getValue(&resp, "SN ?", 1); !isPortIOError(err) {
+			t.Fatalf("read %d on the unplugged port: %v, want an I/O error", i+1, err)
+		}
+	}
+
+	if !unplugged.closed {
+		t.Error("failed port not closed")
+	}
+	if serialPort.port != replugged || portDevice != newPath {
+		t.Fatalf("port after the reconnect is %s, want %s", portDevice, newPath)
+	}
+	if status, err := getValue(&resp, "SN ?", 1); err != nil || status != ACK || resp != "S1" {
+		t.Errorf("getValue after the reconnect = %d, %q, %v", status, resp, err)
+	}
+	if portIOErrors != 0 {
+		t.Errorf("%d I/O errors counted after the reconnect, want 0", portIOErrors)
+	}
+}
+
+func TestTimeoutIsNotDisconnect(t *testing.T) {
+	setVar(t, &portIOErrors, 0)
+	setVar(t, &disconnectErrors, 1)
+	checkPortError(errors.New("no data read"))
+	checkPortError(errBCC)
+	if portIOErrors != 0 {
+		t.Errorf("protocol errors counted as %d I/O errors", portIOErrors)
+	}
+}
+
+func TestOpenFailurePolicy(t *testing.T) {
+	setVar(t, &openFailureLimit, 3)
+	setVar(t, &openRetryAt, time.Time{})
+	t.Cleanup(func() { openFailures.Store(0); portDegraded.Store(false) })
+	failed := errors.New("no such device")
+
+	for _, policy := range []string{"retry", "degraded"} {
+		setVar(t, &openFailurePolicy, policy)
+		portOpened()
+		for n := 1; n <= 4; n++ {
+			before := time.Now()
+			portOpenFailed(failed)
+			want := policy == "degraded" && n >= 3
+			if portDegraded.Load() != want {
+				t.Errorf("%s after %d failures: degraded %v, want %v", policy, n, portDegraded.Load(), want)
+			}
+			backoff := RECONNECT_MIN_BACKOFF << (n - 1)
+			if wait := openRetryAt.Sub(before); wait < backoff || wait > backoff+time.Second/10 {
+				t.Errorf("%s after %d failures: next attempt in %v, want %v", policy, n, wait, backoff)
+			}
+		}
+	}
+
+	portOpened()
+	if portDegraded.Load() || openFailures.Load() != 0 || !openRetryAt.IsZero() {
+		t.Error("a successful open did not reset the failure state")
+	}
+
+	// The backoff is capped
+	for i := 0; i < 10; i++ {
+		portOpenFailed(failed)
+	}
+	if wait := time.Until(openRetryAt); wait > RECONNECT_MAX_BACKOFF {
+		t.Errorf("next attempt in %v, want at most %v", wait, RECONNECT_MAX_BACKOFF)
+	}
+}
+
+func TestOpenDelayBeforeFirstCommand(t *testing.T) {
+	var firstCommand time.Time
+	port := &fakePort{reply: func(adr byte, cmd string) []byte {
+		if firstCommand.IsZero() {
+			firstCommand = time.Now()
+		}
+		return answer(map[string]string{"SN ?": "S1", "MEA CH 1 ?": "20"})(adr, cmd)
+	}}
+	stored := scanTest(t, port, 1)
+	const delay = 150 * time.Millisecond
+	setVar(t, &portOpenDelay, delay)
+	var opened time.Time
+	setVar(t, &openSerial, func(config *serial.Config) (io.ReadWriteCloser, error) {
+		opened = time.Now()
+		return port, nil
+	})
+
+	if err := scan(); err != nil {
+		t.Fatal(err)
+	}
+	if wait := firstCommand.Sub(opened); wait < delay {
+		t.Errorf("first command %v after the open, want at least %v", wait, delay)
+	}
+	if len(stored.records()) != 1 {
+		t.Error("the scan after the delay stored no value")
+	}
+}
+
+func TestReconnectKeepsWatchdogAlive(t *testing.T) {
+	conn := notifySocket(t)
+	replugged := &fakePort{}
+	scanTest(t, &fakePort{}, 1)
+	usePort(t, &fakePort{})
+	setVar(t, &serialDevicePattern, "")
+	setVar(t, &sdWatchdogEvery, time.Nanosecond)
+	setVar(t, &sdLastWatchdog, time.Time{})
+
+	var sleeps []time.Duration
+	setVar(t, &reconnectSleep, func(d time.Duration) { sleeps = append(sleeps, d) })
+	setVar(t, &openSerial, func(config *serial.Config) (io.ReadWriteCloser, error) {
+		if len(sleeps) < 4 {
+			return nil, errors.New("no such device")
+		}
+		return replugged, nil
+	})
+
+	reconnectPort(errors.New("unplugged"))
+	if serialPort.port != replugged {
+		t.Fatal("port not reconnected")
+	}
+	want := []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 8 * time.Second}
+	if !slices.Equal(sleeps, want) {
+		t.Errorf("backoff %v, want %v", sleeps, want)
+	}
+	for i := range sleeps {
+		if msg := received(conn); msg != "WATCHDOG=1" {
+			t.Fatalf("notification %d = %q, want a watchdog ping per attempt", i+1, msg)
+		}
+	}
+}
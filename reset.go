@@ -0,0 +1,93 @@
+package main
+
+import (
+	"log/slog"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Device reset configuration
+var (
+	resetCommand    string // "" = no reset recovery
+	resetAfterScans = 3    // consecutive failed scans before a reset
+	resetWait       = 5 * time.Second
+	resetAddresses  map[byte]bool // addresses supporting the reset; nil = all
+)
+
+// Device recovery state
+var (
+	failedScans  [MAXNUMADR]int
+	resetPending [MAXNUMADR]bool
+	resetUntil   [MAXNUMADR]time.Time
+)
+
+// resetSupported reports whether the reset command may be sent to the
+// address at index idx.
+func resetSupported(idx int) bool {
+	if resetCommand == "" {
+		return false
+	}
+	return resetAddresses == nil || resetAddresses[scanAddress[idx]]
+}
+
+// resetting reports whether the address at index idx is still recovering
+// from a reset and must not be polled yet.
+func resetting(idx int) bool {
+	return time.Now().Before(resetUntil[idx])
+}
+
+// scanResult updates the failure count of the address at index idx after a
+// scan and issues a reset once the configured threshold is reached.
+func scanResult(idx int, ok bool) {
+	adr := scanAddress[idx]
+
+	if ok {
+		if resetPending[idx] {
+			slog.Info("device recovered after reset", "address", adr)
+			resetPending[idx] = false
+		}
+		failedScans[idx] = 0
+		return
+	}
+
+	failedScans[idx]++
+	if failedScans[idx] < resetAfterScans || !resetSupported(idx) {
+		return
+	}
+
+	if resetPending[idx] {
+		slog.Warn("device did not recover after reset", "address", adr, "failedScans", failedScans[idx])
+	}
+	slog.Warn("resetting device", "address", adr, "command", resetCommand, "failedScans", failedScans[idx])
+
+	var resp string
+	status, err := getValue(&resp, resetCommand, adr)
+	switch {
+	case err != nil:
+		slog.Warn("device reset not acknowledged", "address", adr, "error", err)
+	case status == ACK:
+		slog.Info("device reset acknowledged", "address", adr)
+	default:
+		slog.Warn("device reset rejected", "address", adr, "status", status)
+	}
+
+	failedScans[idx] = 0
+	resetPending[idx] = true
+	resetUntil[idx] = time.Now().Add(resetWait)
+}
+
+// parseAddressSet parses a comma separated address list into a set. An
+// empty list returns nil, meaning all addresses.
+func parseAddressSet(s string) map[byte]bool {
+	if strings.TrimSpace(s) == "" {
+		return nil
+	}
+	set := make(map[byte]bool)
+	for _, part := range strings.Split(s, ",") {
+		if val, err := strconv.ParseUint(strings.TrimSpace(part), 10, 8); err == nil {
+			set[byte(val)] = true
+		}
+	}
+	return set
+}
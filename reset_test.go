@@ -0,0 +1,102 @@
+package main
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+// serials returns the serial numbers of the records
+func serials(recs []record) []string {
+	var s []string
+	for _, rec := range recs {
+		s = append(s, rec.Serial)
+	}
+	return s
+}
+
+func TestResetAfterFailedScans(t *testing.T) {
+	// Address 2 stops measuring until it is reset
+	var mu sync.Mutex
+	wasReset := false
+	port := &fakePort{reply: func(adr byte, cmd string) []byte {
+		mu.Lock()
+		defer mu.Unlock()
+		switch {
+		case cmd == "SN ?":
+			return frame(ACK, []string{1: "S1", 2: "S2"}[adr])
+		case cmd == "RST" && adr == 2:
+			wasReset = true
+			return frame(ACK, "")
+		case cmd == "MEA CH 1 ?" && (adr == 1 || wasReset):
+			return frame(ACK, "21.5")
+		}
+		return nil
+	}}
+	stored := scanTest(t, port, 1, 2)
+	setVar(t, &resetCommand, "RST")
+	setVar(t, &resetAfterScans, 2)
+	setVar(t, &resetWait, 300*time.Millisecond)
+
+	scan()
+	if resetPending[1] {
+		t.Fatal("reset after one failed scan, want it after 2")
+	}
+	scan()
+	if !resetPending[1] || !wasReset {
+		t.Fatal("no reset after 2 failed scans")
+	}
+	stored.records()
+
+	// While the device recovers it is neither polled nor stored
+	sent := len(port.commands())
+	scan()
+	for _, c := range port.commands()[sent:] {
+		if c.adr == 2 {
+			t.Errorf("%q sent to address 2 during its reset wait", c.cmd)
+		}
+	}
+	if got := serials(stored.records()); len(got) != 1 || got[0] != "S1" {
+		t.Errorf("stored serials %q during the reset wait, want only S1", got)
+	}
+
+	time.Sleep(resetWait)
+	scan()
+	if resetPending[1] || failedScans[1] != 0 {
+		t.Errorf("address 2 not recovered: pending %v, failed scans %d", resetPending[1], failedScans[1])
+	}
+	recs := stored.records()
+	if len(recs) != 2 || recs[1].Serial != "S2" || recs[1].Value != "21.5" {
+		t.Errorf("records after the reset wait = %+v, want S1 and S2 with 21.5", recs)
+	}
+}
+
+func TestResetOnlySupportedAddresses(t *testing.T) {
+	setAddresses(t, 1, 2)
+	setVar(t, &resetCommand, "RST")
+	setVar(t, &resetAddresses, map[byte]bool{2: true})
+	if resetSupported(0) || !resetSupported(1) {
+		t.Errorf("resetSupported = %v, %v; want false, true", resetSupported(0), resetSupported(1))
+	}
+}
+
+func TestResetAddressesEmptyMeansAll(t *testing.T) {
+	setAddresses(t, 1, 2)
+	setVar(t, &resetCommand, "RST")
+	setVar(t, &resetAddresses, map[byte]bool{2: true})
+	k := lookupConfigKey("reset.addresses")
+	for _, value := range []string{"", "  "} {
+		if err := k.apply(k.key, value); err != nil {
+			t.Fatal(err)
+		}
+		if resetAddresses != nil || !resetSupported(0) || !resetSupported(1) {
+			t.Errorf("reset.addresses = %q: %v, want all addresses", value, resetAddresses)
+		}
+	}
+	if err := k.apply(k.key, "2, x"); err != nil {
+		t.Fatal(err)
+	}
+	if resetSupported(0) || !resetSupported(1) {
+		t.Errorf("reset.addresses = \"2, x\": %v, want address 2 only", resetAddresses)
+	}
+}
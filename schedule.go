@@ -0,0 +1,122 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"sync/atomic"
+	"time"
+)
+
+// Wall clock aligned scans. With scanAlign set, scans start on the
+// multiples of scanAlign counted from the Unix epoch plus scanAlignOffset,
+// e.g. on every full minute, instead of minScanDelaySeconds after the last.
+//
+// Slots are wall clock times, everything else is timed on the monotonic
+// clock: lastScan and scanStart come from time.Now, so the minimum delay
+// and the overrun check are immune to clock steps, and a slot moved more
+// than one interval away by a step is recalculated.
+var (
+	scanAlign       time.Duration // 0 = minimum delay between scans
+	scanAlignOffset time.Duration
+)
+
+var (
+	scanSlot     time.Time // start of the next aligned scan
+	scanStart    time.Time // monotonic start of the current aligned scan
+	skippedSlots atomic.Int64
+)
+
+// nextSlot returns the first aligned slot boundary after t
+func nextSlot(t time.Time) time.Time {
+	ref := time.Unix(0, 0).Add(scanAlignOffset)
+	slot := ref.Add(t.Sub(ref) / scanAlign * scanAlign)
+	for !slot.After(t) {
+		slot = slot.Add(scanAlign)
+	}
+	return slot
+}
+
+// scanWait returns how long to wait at now before the next scan is due
+func scanWait(now time.Time) time.Duration {
+	if wait := openRetryAt.Sub(now); wait > 0 {
+		return wait
+	}
+	if scanAlign <= 0 {
+		return time.Duration(minScanDelaySeconds*float64(time.Second)) - now.Sub(lastScan)
+	}
+	if scanSlot.IsZero() || scanSlot.Sub(now) > scanAlign {
+		// First scan, or the clock was set back
+		scanSlot = nextSlot(now)
+	}
+	wait := scanSlot.Sub(now)
+	if wait <= 0 {
+		scanStart = now
+	}
+	return wait
+}
+
+// scheduleNextScan moves to the slot after a scan ended at now. Slots the
+// scan overran are skipped rather than started late. A scan shorter than
+// the interval never overran, even if the clock was set forward meanwhile.
+func scheduleNextScan(now time.Time) {
+	if scanAlign <= 0 {
+		return
+	}
+	slot := scanSlot
+	scanSlot = nextSlot(now)
+	if now.Sub(scanStart) < scanAlign {
+		return
+	}
+	if skipped := int64(scanSlot.Sub(slot)/scanAlign) - 1; skipped > 0 {
+		skippedSlots.Add(skipped)
+		slog.Warn("scan overran its slot", "slot", slot, "skipped", skipped, "next", scanSlot)
+	}
+}
+
+// Quiet hours, a daily window without serial traffic. The window may span
+// midnight, e.g. 22:00 to 06:00. Minutes of the day, -1 = no quiet hours.
+var (
+	quietStart  = -1
+	quietEnd    = -1
+	quietActive bool
+)
+
+// parseQuietTime parses an "HH:MM" quiet hours boundary into minutes, -1
+// for "" turning the quiet hours off
+func parseQuietTime(key, s string) (int, error) {
+	if s == "" {
+		return -1, nil
+	}
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s %q", key, s)
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+// inQuietHours reports whether now lies in the quiet hours window
+func inQuietHours(now time.Time) bool {
+	if quietStart < 0 || quietEnd < 0 || quietStart == quietEnd {
+		return false
+	}
+	m := now.Hour()*60 + now.Minute()
+	if quietStart < quietEnd {
+		return m >= quietStart && m < quietEnd
+	}
+	return m >= quietStart || m < quietEnd
+}
+
+// quietHours reports whether scanning is paused at now and logs the
+// transitions into and out of the window
+func quietHours(now time.Time) bool {
+	quiet := inQuietHours(now)
+	if quiet != quietActive {
+		if quiet {
+			slog.Info("quiet hours started, scanning paused")
+		} else {
+			slog.Info("quiet hours ended, scanning resumed")
+		}
+		quietActive = quiet
+	}
+	return quiet
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// alignTest sets up aligned scans of the given interval and offset
+func alignTest(t *testing.T, align, offset time.Duration) {
+	t.Helper()
+	setVar(t, &scanAlign, align)
+	setVar(t, &scanAlignOffset, offset)
+	setVar(t, &scanSlot, time.Time{})
+	setVar(t, &scanStart, time.Time{})
+	setVar(t, &openRetryAt, time.Time{})
+	skipped := skippedSlots.Load()
+	t.Cleanup(func() { skippedSlots.Store(skipped) })
+	skippedSlots.Store(0)
+}
+
+func at(hms string) time.Time {
+	t, err := time.Parse("15:04:05", hms)
+	if err != nil {
+		panic(err)
+	}
+	return time.Date(2024, 3, 1, t.Hour(), t.Minute(), t.Second(), 0, time.UTC)
+}
+
+func TestNextSlot(t *testing.T) {
+	alignTest(t, time.Minute, 0)
+	for now, want := range map[string]string{
+		"12:00:00": "12:01:00",
+		"12:00:01": "12:01:00",
+		"12:00:59": "12:01:00",
+	} {
+		if slot := nextSlot(at(now)); !slot.Equal(at(want)) {
+			t.Errorf("nextSlot(%s) = %s, want %s", now, slot.Format("15:04:05"), want)
+		}
+	}
+
+	alignTest(t, 5*time.Minute, 15*time.Second)
+	for now, want := range map[string]string{
+		"12:00:00": "12:00:15",
+		"12:00:15": "12:05:15",
+		"12:04:00": "12:05:15",
+	} {
+		if slot := nextSlot(at(now)); !slot.Equal(at(want)) {
+			t.Errorf("nextSlot(%s) with offset = %s, want %s", now, slot.Format("15:04:05"), want)
+		}
+	}
+}
+
+func TestAlignedScanWait(t *testing.T) {
+	alignTest(t, time.Minute, 0)
+
+	if wait := scanWait(at("12:00:20")); wait != 40*time.Second {
+		t.Errorf("wait at 12:00:20 = %v, want 40s", wait)
+	}
+	if wait := scanWait(at("12:01:00")); wait != 0 {
+		t.Errorf("wait on the slot = %v, want 0", wait)
+	}
+	if !scanStart.Equal(at("12:01:00")) {
+		t.Errorf("scan started at %v, want the slot", scanStart)
+	}
+	scheduleNextScan(at("12:01:05"))
+	if wait := scanWait(at("12:01:05")); wait != 55*time.Second {
+		t.Errorf("wait after a short scan = %v, want 55s", wait)
+	}
+
+	// A scan running past the next slot skips it
+	scanWait(at("12:02:00"))
+	scheduleNextScan(at("12:03:30"))
+	if !scanSlot.Equal(at("12:04:00")) || skippedSlots.Load() != 1 {
+		t.Errorf("after an overrun next slot %v skipped %d, want 12:04:00 and 1", scanSlot, skippedSlots.Load())
+	}
+}
+
+func TestClockStepBack(t *testing.T) {
+	alignTest(t, time.Minute, 0)
+	scanWait(at("12:00:10"))
+
+	// Set back by an hour, the slot of 12:01 would be an hour away
+	if wait := scanWait(at("11:00:10")); wait != 50*time.Second {
+		t.Errorf("wait after the clock was set back = %v, want 50s", wait)
+	}
+}
+
+func TestClockStepForward(t *testing.T) {
+	alignTest(t, time.Minute, 0)
+	scanStart = time.Now()
+	// The slot was computed before the clock was set forward by an hour, so
+	// the wall clock has left it far behind while the scan took no time
+	scanSlot = scanStart.Round(0).Add(-time.Hour)
+	end := time.Now()
+	scheduleNextScan(end)
+	if skippedSlots.Load() != 0 {
+		t.Errorf("a short scan skipped %d slots after a clock step", skippedSlots.Load())
+	}
+	if wait := scanSlot.Sub(end); wait <= 0 || wait > time.Minute {
+		t.Errorf("next slot in %v, want within the interval", wait)
+	}
+}
+
+func TestQuietHours(t *testing.T) {
+	setVar(t, &quietActive, false)
+	for _, c := range []struct {
+		start, end string
+		inside     []string
+		outside    []string
+	}{
+		{"08:00", "17:30", []string{"08:00:00", "12:00:00", "17:29:59"}, []string{"07:59:59", "17:30:00", "23:00:00"}},
+		{"22:00", "06:00", []string{"22:00:00", "23:59:59", "00:00:00", "05:59:59"}, []string{"06:00:00", "12:00:00", "21:59:59"}},
+	} {
+		start, _ := parseQuietTime("quiet.start", c.start)
+		end, _ := parseQuietTime("quiet.end", c.end)
+		setVar(t, &quietStart, start)
+		setVar(t, &quietEnd, end)
+		for _, hms := range c.inside {
+			if !inQuietHours(at(hms)) {
+				t.Errorf("%s-%s: %s not in the quiet hours", c.start, c.end, hms)
+			}
+		}
+		for _, hms := range c.outside {
+			if inQuietHours(at(hms)) {
+				t.Errorf("%s-%s: %s in the quiet hours", c.start, c.end, hms)
+			}
+		}
+	}
+
+	setVar(t, &quietStart, -1)
+	if inQuietHours(at("23:00:00")) {
+		t.Error("quiet hours without a window")
+	}
+	if _, err := parseQuietTime("quiet.start", "25:00"); err == nil {
+		t.Error("quiet.start 25:00 accepted")
+	}
+}
+
+func TestQuietHoursEmptyIsOff(t *testing.T) {
+	setVar(t, &quietStart, -1)
+	setVar(t, &quietEnd, -1)
+	for _, end := range []string{"00:00", ""} {
+		if err := loadTestConfig(t, `quiet.start = ""`, `quiet.end = "`+end+`"`, `scanAddresses = "1"`); err != nil {
+			t.Fatal(err)
+		}
+		if quietStart != -1 {
+			t.Errorf("quiet.start \"\" parsed as %d, want -1", quietStart)
+		}
+		// A window needs both boundaries
+		if inQuietHours(at("00:00:00")) || inQuietHours(at("12:00:00")) {
+			t.Errorf("quiet hours with quiet.start \"\" and quiet.end %q", end)
+		}
+	}
+}
+
+func TestQuietHoursTransitions(t *testing.T) {
+	setVar(t, &quietStart, 22*60)
+	setVar(t, &quietEnd, 6*60)
+	setVar(t, &quietActive, false)
+	log := captureLog(t)
+
+	for _, hms := range []string{"21:59:00", "22:00:00", "03:00:00", "06:00:00", "06:01:00"} {
+		quietHours(at(hms))
+	}
+	out := log.String()
+	if strings.Count(out, "quiet hours started") != 1 || strings.Count(out, "quiet hours ended") != 1 {
+		t.Errorf("log %q, want one start and one end", out)
+	}
+	if quietActive {
+		t.Error("still quiet after the window")
+	}
+}
+
+func TestFinalScanSkippedInQuietHours(t *testing.T) {
+	port := &fakePort{reply: answer(map[string]string{"SN ?": "S1", "MEA CH 1 ?": "19.5"})}
+	stored := scanTest(t, port, 1)
+	// A window around the current minute
+	now := time.Now()
+	minute := now.Hour()*60 + now.Minute()
+	setVar(t, &quietStart, minute)
+	setVar(t, &quietEnd, (minute+2)%(24*60))
+
+	runFinalScan()
+	if !scanMu.TryLock() {
+		t.Fatal("final scan took the scan lock in the quiet hours")
+	}
+	scanMu.Unlock()
+	if cmds := port.commands(); len(cmds) != 0 || len(stored.records()) != 0 {
+		t.Errorf("final scan ran in the quiet hours: sent %v", cmds)
+	}
+}
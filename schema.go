@@ -0,0 +1,278 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+)
+
+// dbSchema maps the logical tables ("channel") and columns ("channel.id")
+// used by the writers to the names in the target database. Override with
+// "schema.<key>" entries in the config file.
+var dbSchema = map[string]string{
+	"channel":            "channel",
+	"channel.id":         "id",
+	"channel.id_unit":    "id_unit",
+	"channel.status":     "status",
+	"channel.error_code": "error_code",
+	"channel.error_text": "error_text",
+	"channel.error_raw":  "error_raw",
+	"unit":               "unit",
+	"unit.id":            "id",
+	"unit.serialnumber":  "serialnumber",
+	"data":               "data",
+	"data.id_channel":    "id_channel",
+	"data.datetime":      "datetime",
+	"data.value":         "value",
+	"data.first_try":     "first_try",
+	"data.retries":       "retries",
+	"data.unit":          "unit",
+	"data.value_index":   "value_index",
+	"data.quality":       "quality",
+	"data.raw_value":     "raw_value",
+	"data.tag":           "tag",
+	"data.source":        "source",
+	"data.checksum":      "checksum",
+	"data.settings":      "settings",
+	"data.runtime_hours": "runtime_hours",
+	"data.device":        "device",
+}
+
+// storeQuality adds the frame quality columns to the data table,
+// storeQualityFlag the startup/normal quality flag, storeSource the source
+// tag telling live readings from imported or replayed ones and storeDevice
+// the serial device path
+var (
+	storeQuality     = false
+	storeQualityFlag = false
+	storeSource      = false
+	sourceTag        = "live"
+	storeChecksum    = false
+	storeDevice      = false
+)
+
+// optionalColumns are only written, and checked, when enabled
+var optionalColumns = map[string]*bool{
+	"channel.error_code": &storeErrorCodes,
+	"channel.error_text": &storeErrorCodes,
+	"channel.error_raw":  &storeErrorCodes,
+	"data.first_try":     &storeQuality,
+	"data.retries":       &storeQuality,
+	"data.unit":          &storeUnit,
+	"data.value_index":   &storeIndex,
+	"data.quality":       &storeQualityFlag,
+	"data.raw_value":     &storeRawValue,
+	"data.tag":           &storeTag,
+	"data.source":        &storeSource,
+	"data.checksum":      &storeChecksum,
+	"data.settings":      &storeSettings,
+	"data.runtime_hours": &storeRuntime,
+	"data.device":        &storeDevice,
+}
+
+// columnUsed reports whether a logical column is written
+func columnUsed(key string) bool {
+	enabled, ok := optionalColumns[key]
+	return !ok || *enabled
+}
+
+// Column type families accepted by the schema check
+var (
+	intTypes  = []string{"integer", "int", "bigint", "smallint", "mediumint", "tinyint"}
+	textTypes = []string{"text", "character varying", "varchar", "character", "char", "tinytext", "mediumtext", "longtext"}
+	timeTypes = []string{"timestamp without time zone", "timestamp with time zone", "timestamp", "datetime", "date"}
+	numTypes  = []string{"numeric", "decimal", "real", "double precision", "double", "float"}
+	boolTypes = []string{"boolean", "tinyint", "bit"}
+)
+
+// schemaTypes lists the acceptable data types for each mapped column
+var schemaTypes = map[string][][]string{
+	"channel.id":         {intTypes},
+	"channel.id_unit":    {intTypes},
+	"channel.status":     {textTypes},
+	"channel.error_code": {textTypes, intTypes},
+	"channel.error_text": {textTypes},
+	"channel.error_raw":  {textTypes},
+	"unit.id":            {intTypes},
+	"unit.serialnumber":  {textTypes, intTypes},
+	"data.id_channel":    {intTypes},
+	"data.datetime":      {timeTypes, textTypes},
+	"data.value":         {numTypes, textTypes, intTypes},
+	"data.first_try":     {boolTypes},
+	"data.retries":       {intTypes},
+	"data.unit":          {textTypes},
+	"data.value_index":   {intTypes},
+	"data.quality":       {textTypes},
+	"data.raw_value":     {textTypes, numTypes},
+	"data.tag":           {textTypes},
+	"data.source":        {textTypes},
+	"data.checksum":      {textTypes},
+	"data.settings":      {textTypes},
+	"data.runtime_hours": {textTypes, numTypes, intTypes},
+	"data.device":        {textTypes},
+}
+
+// dataTables routes measurements of a type to their own table, set with
+// "schema.table.<type>" entries. Types without an entry go to "data".
+var dataTables = make(map[string]string)
+
+var (
+	dbDriver            = "postgres" // "postgres" or "mysql"
+	verifySchemaAtStart = false
+)
+
+// setSchemaName applies a "schema.<key>" config entry
+func setSchemaName(key, name string) error {
+	if mtype, ok := strings.CutPrefix(key, "table."); ok {
+		if mtype == "" || name == "" {
+			return fmt.Errorf("invalid schema key %q", key)
+		}
+		dataTables[mtype] = name
+		return nil
+	}
+	if _, ok := dbSchema[key]; !ok {
+		return fmt.Errorf("unknown schema key %q", key)
+	}
+	if name == "" {
+		return fmt.Errorf("empty name for schema key %q", key)
+	}
+	dbSchema[key] = name
+	return nil
+}
+
+// quoteIdent quotes an identifier for the configured driver so reserved
+// words and mixed case names are used verbatim.
+func quoteIdent(name string) string {
+	if dbDriver == "mysql" {
+		return "`" + strings.ReplaceAll(name, "`", "``") + "`"
+	}
+	return `"` + strings.ReplaceAll(name, `"`, `""`) + `"`
+}
+
+// placeholder returns the n-th (1 based) query parameter marker
+func placeholder(n int) string {
+	if dbDriver == "mysql" || dbDriver == "sqlite" {
+		return "?"
+	}
+	return fmt.Sprintf("$%d", n)
+}
+
+// placeholders returns a comma separated list of n parameter markers
+func placeholders(n int) string {
+	marks := make([]string, n)
+	for i := range marks {
+		marks[i] = placeholder(i + 1)
+	}
+	return strings.Join(marks, ", ")
+}
+
+// tbl returns the quoted database name of a logical table
+func tbl(table string) string {
+	return quoteIdent(dbSchema[table])
+}
+
+// dataTable returns the quoted table measurements of type mtype go to
+func dataTable(mtype string) string {
+	if name, ok := dataTables[mtype]; ok {
+		return quoteIdent(name)
+	}
+	return tbl("data")
+}
+
+// col returns the quoted database name of a logical column
+func col(key string) string {
+	return quoteIdent(dbSchema[key])
+}
+
+// qcol returns a quoted logical column qualified with its table name
+func qcol(key string) string {
+	table, _, _ := strings.Cut(key, ".")
+	return tbl(table) + "." + col(key)
+}
+
+// columnsQuery returns the query of the column names and data types of a
+// table in the current schema. The "sqlite" dialect is used by the tests.
+func columnsQuery() string {
+	switch dbDriver {
+	case "sqlite":
+		return "SELECT name, type FROM pragma_table_info(?)"
+	case "mysql":
+		return "SELECT column_name, data_type FROM information_schema.columns " +
+			"WHERE table_schema = DATABASE() AND table_name = ?"
+	}
+	return "SELECT column_name, data_type FROM information_schema.columns " +
+		"WHERE table_schema = current_schema() AND table_name = $1"
+}
+
+// verifySchema checks that every mapped table and column exists in the
+// database with a compatible type. All problems are reported together.
+func verifySchema(sock *sql.DB) error {
+	var problems []string
+
+	// Logical table and database name of every table written to
+	tables := [][2]string{{"channel", dbSchema["channel"]}, {"unit", dbSchema["unit"]}, {"data", dbSchema["data"]}}
+	for _, mtype := range slices.Sorted(maps.Keys(dataTables)) {
+		tables = append(tables, [2]string{"data", dataTables[mtype]})
+	}
+
+	for _, t := range tables {
+		table, name := t[0], t[1]
+		rows, err := sock.Query(columnsQuery(), name)
+		if err != nil {
+			return fmt.Errorf("schema query for table %s failed: %w", name, err)
+		}
+		columns := make(map[string]string)
+		for rows.Next() {
+			var column, dataType string
+			if err := rows.Scan(&column, &dataType); err != nil {
+				rows.Close()
+				return fmt.Errorf("schema query for table %s failed: %w", name, err)
+			}
+			// Declared types may carry a length, e.g. varchar(32)
+			dataType, _, _ = strings.Cut(strings.ToLower(dataType), "(")
+			columns[column] = strings.TrimSpace(dataType)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return fmt.Errorf("schema query for table %s failed: %w", name, err)
+		}
+
+		if len(columns) == 0 {
+			problems = append(problems, fmt.Sprintf("table %s does not exist", name))
+			continue
+		}
+
+		for _, key := range slices.Sorted(maps.Keys(schemaTypes)) {
+			families := schemaTypes[key]
+			if !strings.HasPrefix(key, table+".") || !columnUsed(key) {
+				continue
+			}
+			dataType, ok := columns[dbSchema[key]]
+			if !ok {
+				problems = append(problems, fmt.Sprintf("table %s has no column %s", name, dbSchema[key]))
+				continue
+			}
+			if !typeAllowed(dataType, families) {
+				problems = append(problems, fmt.Sprintf("column %s.%s has type %s", name, dbSchema[key], dataType))
+			}
+		}
+	}
+
+	if len(problems) > 0 {
+		return fmt.Errorf("database schema mismatch: %s", strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+func typeAllowed(dataType string, families [][]string) bool {
+	for _, family := range families {
+		for _, t := range family {
+			if dataType == t {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"maps"
+	"strings"
+	"testing"
+)
+
+const (
+	channelDDL = "CREATE TABLE channel (id integer PRIMARY KEY, id_unit integer, status varchar(16))"
+	unitDDL    = "CREATE TABLE unit (id integer PRIMARY KEY, serialnumber text)"
+	dataDDL    = "CREATE TABLE data (id_channel integer, datetime timestamp, value real)"
+)
+
+func TestVerifySchema(t *testing.T) {
+	sock := testDB(t, channelDDL, unitDDL, dataDDL)
+	if err := verifySchema(sock); err != nil {
+		t.Errorf("verifySchema of a correct schema: %v", err)
+	}
+}
+
+func TestVerifySchemaMissingColumn(t *testing.T) {
+	sock := testDB(t, channelDDL, unitDDL,
+		"CREATE TABLE data (id_channel integer, datetime timestamp)")
+	err := verifySchema(sock)
+	if err == nil || !strings.Contains(err.Error(), "table data has no column value") {
+		t.Errorf("verifySchema = %v, want the missing data.value column", err)
+	}
+}
+
+func TestVerifySchemaReportsAllProblems(t *testing.T) {
+	setVar(t, &storeQuality, true)
+	sock := testDB(t, unitDDL,
+		"CREATE TABLE data (id_channel integer, datetime timestamp, value real, first_try boolean, retries text)")
+	err := verifySchema(sock)
+	if err == nil {
+		t.Fatal("verifySchema of a broken schema succeeded")
+	}
+	for _, want := range []string{"table channel does not exist", "column data.retries has type text"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("verifySchema = %v, want %q reported", err, want)
+		}
+	}
+}
+
+func TestQuoteIdent(t *testing.T) {
+	tests := []struct {
+		driver, name, want string
+	}{
+		{"postgres", "value", `"value"`},
+		{"postgres", "order", `"order"`},
+		{"postgres", "DataTable", `"DataTable"`},
+		{"postgres", `odd"name`, `"odd""name"`},
+		{"mysql", "value", "`value`"},
+		{"mysql", "order", "`order`"},
+		{"mysql", "DataTable", "`DataTable`"},
+		{"mysql", "odd`name", "`odd``name`"},
+	}
+	for _, tt := range tests {
+		setVar(t, &dbDriver, tt.driver)
+		if got := quoteIdent(tt.name); got != tt.want {
+			t.Errorf("%s quoteIdent(%q) = %s, want %s", tt.driver, tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestQueriesUseMappedNames(t *testing.T) {
+	setVar(t, &dbDriver, "postgres")
+	setVar(t, &dbSchema, maps.Clone(dbSchema))
+	if err := setSchemaName("channel", "Channels"); err != nil {
+		t.Fatal(err)
+	}
+	if err := setSchemaName("unit.serialnumber", "select"); err != nil {
+		t.Fatal(err)
+	}
+	want := `SELECT "Channels"."id" FROM "Channels" LEFT JOIN "unit" ON "Channels"."id_unit" = "unit"."id" WHERE "unit"."select" = $1`
+	if got := channelQuery(); got != want {
+		t.Errorf("channelQuery() =\n%s\nwant\n%s", got, want)
+	}
+
+	setVar(t, &dbDriver, "mysql")
+	want = "SELECT `Channels`.`id` FROM `Channels` LEFT JOIN `unit` ON `Channels`.`id_unit` = `unit`.`id` WHERE `unit`.`select` = ?"
+	if got := channelQuery(); got != want {
+		t.Errorf("channelQuery() =\n%s\nwant\n%s", got, want)
+	}
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"log/slog"
+	"net"
+	"os"
+	"strconv"
+	"time"
+)
+
+// systemd Type=notify support
+var (
+	systemdNotify   = false
+	sdReadySent     = false
+	sdWatchdogEvery time.Duration // 0 = watchdog not enabled by systemd
+	sdLastWatchdog  time.Time
+)
+
+// sdNotify sends a state to the systemd notify socket. It does nothing when
+// not started by systemd with NOTIFY_SOCKET set.
+func sdNotify(state string) error {
+	sock := os.Getenv("NOTIFY_SOCKET")
+	if sock == "" {
+		return nil
+	}
+	if sock[0] == '@' {
+		sock = "\x00" + sock[1:] // abstract namespace socket
+	}
+
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: sock, Net: "unixgram"})
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	_, err = conn.Write([]byte(state))
+	return err
+}
+
+// sdInit reads the watchdog interval systemd expects pings at
+func sdInit() {
+	if !systemdNotify {
+		return
+	}
+	usec, err := strconv.ParseInt(os.Getenv("WATCHDOG_USEC"), 10, 64)
+	if err != nil || usec <= 0 {
+		return
+	}
+	if pid := os.Getenv("WATCHDOG_PID"); pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		return
+	}
+	// Ping at half the timeout as recommended by sd_watchdog_enabled(3)
+	sdWatchdogEvery = time.Duration(usec) * time.Microsecond / 2
+}
+
+// sdReady reports readiness once, after the first successful scan
+func sdReady() {
+	if !systemdNotify || sdReadySent {
+		return
+	}
+	if err := sdNotify("READY=1"); err != nil {
+		slog.Error("systemd notify failed", "error", err)
+		return
+	}
+	sdReadySent = true
+}
+
+// sdWatchdog pings the systemd watchdog when due. It is called from the
+// scan loop so a hung loop stops the pings.
+func sdWatchdog() {
+	if sdWatchdogEvery == 0 || time.Since(sdLastWatchdog) < sdWatchdogEvery {
+		return
+	}
+	if err := sdNotify("WATCHDOG=1"); err != nil {
+		slog.Error("systemd watchdog ping failed", "error", err)
+	}
+	sdLastWatchdog = time.Now()
+}
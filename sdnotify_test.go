@@ -0,0 +1,78 @@
+package main
+
+import (
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// notifySocket listens on a mock systemd notify socket
+func notifySocket(t *testing.T) *net.UnixConn {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "notify")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: path, Net: "unixgram"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	t.Setenv("NOTIFY_SOCKET", path)
+	return conn
+}
+
+// received returns the next notification, "" if none arrives
+func received(conn *net.UnixConn) string {
+	buf := make([]byte, 256)
+	conn.SetReadDeadline(time.Now().Add(50 * time.Millisecond))
+	n, err := conn.Read(buf)
+	if err != nil {
+		return ""
+	}
+	return string(buf[:n])
+}
+
+func TestReadyAfterFirstScan(t *testing.T) {
+	conn := notifySocket(t)
+	setVar(t, &systemdNotify, true)
+	setVar(t, &sdReadySent, false)
+
+	sdReady()
+	if msg := received(conn); msg != "READY=1" {
+		t.Errorf("notification %q, want READY=1", msg)
+	}
+	sdReady()
+	if msg := received(conn); msg != "" {
+		t.Errorf("notification %q after the second scan, want readiness sent once", msg)
+	}
+}
+
+func TestWatchdogPing(t *testing.T) {
+	conn := notifySocket(t)
+	setVar(t, &systemdNotify, true)
+	setVar(t, &sdWatchdogEvery, time.Duration(0))
+	setVar(t, &sdLastWatchdog, time.Time{})
+	t.Setenv("WATCHDOG_USEC", "2000000")
+	t.Setenv("WATCHDOG_PID", "")
+
+	sdInit()
+	if sdWatchdogEvery != time.Second {
+		t.Fatalf("watchdog interval %v, want half of WATCHDOG_USEC", sdWatchdogEvery)
+	}
+	sdWatchdog()
+	if msg := received(conn); msg != "WATCHDOG=1" {
+		t.Errorf("notification %q, want WATCHDOG=1", msg)
+	}
+	sdWatchdog()
+	if msg := received(conn); msg != "" {
+		t.Errorf("watchdog pinged again before its interval: %q", msg)
+	}
+}
+
+func TestNotifyWithoutSocket(t *testing.T) {
+	t.Setenv("NOTIFY_SOCKET", "")
+	setVar(t, &systemdNotify, true)
+	setVar(t, &sdReadySent, false)
+	if err := sdNotify("READY=1"); err != nil {
+		t.Errorf("sdNotify without a socket: %v", err)
+	}
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Measurement commands. A measurement is a sequence of commands separated
+// by ";" where only the response to the last one is stored, e.g.
+// "RNG 2; MEA CH 1 ?" selects a range before reading.
+var (
+	measureDefault   = []string{"MEA CH 1 ?"}
+	measureByAddress = make(map[byte][]string)
+)
+
+// Measurement types, e.g. "temperature" or "humidity". The type selects the
+// table a measurement is stored in.
+var (
+	measureTypeDefault = "temperature"
+	measureTypes       = make(map[byte]string)
+)
+
+// measureType returns the measurement type of the address at index idx
+func measureType(idx int) string {
+	if mtype, ok := measureTypes[scanAddress[idx]]; ok {
+		return mtype
+	}
+	return measureTypeDefault
+}
+
+// splitCommands splits a ";" separated command sequence
+func splitCommands(s string) []string {
+	var cmds []string
+	for _, cmd := range strings.Split(s, ";") {
+		if cmd = strings.TrimSpace(cmd); cmd != "" {
+			cmds = append(cmds, cmd)
+		}
+	}
+	return cmds
+}
+
+// measureCommands returns the measurement sequence of the address at index idx
+func measureCommands(idx int) []string {
+	if cmds, ok := measureByAddress[scanAddress[idx]]; ok && len(cmds) > 0 {
+		return cmds
+	}
+	return measureDefault
+}
+
+// runSequence sends the setup commands of a measurement, each of which must
+// be acknowledged, followed by the final command whose response is stored
+// in resultStr. A failed step aborts the sequence so it can be retried as a
+// whole.
+func runSequence(resultStr *string, cmds []string, adr byte) (int, error) {
+	var resp string
+	for _, cmd := range cmds[:len(cmds)-1] {
+		status, err := getValue(&resp, cmd, adr)
+		if err != nil {
+			return status, fmt.Errorf("setup command %q failed: %w", cmd, err)
+		}
+		if status == NAK {
+			return status, nil
+		}
+		if status != ACK {
+			return status, fmt.Errorf("setup command %q not acknowledged, status %d", cmd, status)
+		}
+	}
+	status, err := getValue(resultStr, cmds[len(cmds)-1], adr)
+	if err == nil && status == ACK {
+		err = checkPayload(*resultStr, minPayloadMeasure)
+	}
+	return status, err
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestSequenceRetriedAsWhole(t *testing.T) {
+	rangeTries := 0
+	port := &fakePort{reply: func(adr byte, cmd string) []byte {
+		switch cmd {
+		case "RNG 2":
+			// The first setup step goes unanswered
+			if rangeTries++; rangeTries == 1 {
+				return nil
+			}
+			return frame(ACK, "")
+		case "MEA CH 2 ?":
+			return frame(ACK, "48.2")
+		}
+		return nil
+	}}
+	scanTest(t, port, 7)
+	usePort(t, port)
+	setVar(t, &measureRetrys, 3)
+	setVar(t, &measureByAddress, map[byte][]string{7: splitCommands("RNG 2; MEA CH 2 ?")})
+
+	if err := getMeasurement(); err != nil {
+		t.Fatalf("getMeasurement: %v", err)
+	}
+	var cmds []string
+	for _, c := range port.commands() {
+		cmds = append(cmds, c.cmd)
+	}
+	if want := []string{"RNG 2", "RNG 2", "MEA CH 2 ?"}; !slices.Equal(cmds, want) {
+		t.Errorf("commands sent %q, want %q", cmds, want)
+	}
+	if valueStr[0] != "48.2" || measRetries[0] != 1 {
+		t.Errorf("value %q after %d retries, want 48.2 after 1", valueStr[0], measRetries[0])
+	}
+}
+
+func TestSequenceSetupNotAcknowledged(t *testing.T) {
+	port := &fakePort{reply: func(adr byte, cmd string) []byte {
+		if cmd == "RNG 2" {
+			return frame('X', "")
+		}
+		return frame(ACK, "1.0")
+	}}
+	scanTest(t, port, 7)
+	usePort(t, port)
+
+	var value string
+	if _, err := runSequence(&value, []string{"RNG 2", "MEA CH 2 ?"}, 7); err == nil {
+		t.Error("sequence with an unacknowledged setup step succeeded")
+	}
+	if cmds := port.commands(); len(cmds) != 1 {
+		t.Errorf("%d commands sent, want the sequence aborted after the setup step", len(cmds))
+	}
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// importDir is scanned for backfill files before each scan, "" = none. Each
+// *.csv file holds serial,type,time,value rows with an RFC 3339 time and is
+// renamed to *.imported once its rows are stored.
+var importDir string
+
+// bufferSize is the number of records kept while the store is unreachable,
+// 0 = none. They are replayed after the next successful write.
+var (
+	bufferSize int
+	buffered   []record
+)
+
+// importBackfill stores the rows of the CSV files in importDir with the
+// "import" source tag
+func importBackfill() {
+	if importDir == "" {
+		return
+	}
+	files, err := filepath.Glob(filepath.Join(importDir, "*.csv"))
+	if err != nil {
+		slog.Error("import failed", "dir", importDir, "error", err)
+		return
+	}
+	for _, file := range files {
+		stored, err := importFile(file)
+		if err != nil {
+			slog.Error("import failed", "file", file, "stored", stored, "error", err)
+			continue
+		}
+		if err := os.Rename(file, file+".imported"); err != nil {
+			slog.Error("import failed", "file", file, "error", err)
+			continue
+		}
+		slog.Info("imported backfill file", "file", file, "stored", stored)
+	}
+}
+
+// importFile stores the rows of one backfill file and returns the number
+// stored. Rows the store rejects are logged and skipped, a malformed file
+// stops at the bad row.
+func importFile(file string) (int, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	r := csv.NewReader(f)
+	r.FieldsPerRecord = 4
+	r.TrimLeadingSpace = true
+	stored := 0
+	for {
+		row, err := r.Read()
+		if err == io.EOF {
+			return stored, nil
+		}
+		if err != nil {
+			return stored, err
+		}
+		ts, err := time.Parse(time.RFC3339, row[2])
+		if err != nil {
+			line, _ := r.FieldPos(2)
+			return stored, fmt.Errorf("line %d: %w", line, err)
+		}
+		rec := record{Serial: row[0], Type: row[1], Time: ts, Value: row[3], Source: "import", Quality: "normal"}
+		if status := dataStore.write(rec); status != 0 {
+			slog.Warn("import row not stored", "file", file, "serNoStr", rec.Serial, "status", status)
+			continue
+		}
+		stored++
+	}
+}
+
+// storeRecord writes a scanned record. While the store is unreachable the
+// record is buffered, the buffer is replayed after the next successful write.
+func storeRecord(rec record) int {
+	status := dataStore.write(rec)
+	switch {
+	case status == 1 && bufferSize > 0:
+		if len(buffered) >= bufferSize {
+			slog.Warn("write buffer full, dropping the oldest record", "serNoStr", buffered[0].Serial)
+			buffered = buffered[1:]
+		}
+		buffered = append(buffered, rec)
+	case status == 0:
+		replayBuffer()
+	}
+	return status
+}
+
+// replayBuffer writes the buffered records with the "buffer" source tag. It
+// stops at the first record the store is unreachable for, rejected records
+// are dropped.
+func replayBuffer() {
+	for len(buffered) > 0 {
+		rec := buffered[0]
+		rec.Source = "buffer"
+		status := dataStore.write(rec)
+		if status == 1 {
+			return
+		}
+		buffered = buffered[1:]
+		if status != 0 {
+			slog.Warn("buffered record not stored", "serNoStr", rec.Serial, "status", status)
+			continue
+		}
+		storedValues++
+	}
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"database/sql"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+// sourceDB returns a database storing the source tag of each row for the
+// serial numbers S1 and S2
+func sourceDB(t *testing.T) *sql.DB {
+	t.Helper()
+	sock := testDB(t, channelDDL, unitDDL,
+		"CREATE TABLE data (id_channel integer, datetime timestamp, value real, source varchar(8))")
+	provision(t, sock, "S1", "S2")
+	setVar(t, &storeSource, true)
+	setVar(t, &dataStore, store(dbStore{}))
+	return sock
+}
+
+func TestSourceTagStamped(t *testing.T) {
+	scanTest(t, &fakePort{reply: answer(map[string]string{"SN ?": "S1", "MEA CH 1 ?": "20"})}, 1)
+	sock := sourceDB(t)
+
+	if err := scan(); err != nil {
+		t.Fatal(err)
+	}
+	if sources := dataColumn(t, sock, "source"); !slices.Equal(sources, []string{"live"}) {
+		t.Errorf("stored sources %q, want live", sources)
+	}
+}
+
+func TestSourceTagConfigured(t *testing.T) {
+	if err := loadTestConfig(t, `db.source = "import"`, `scanAddresses = "1"`); err != nil {
+		t.Fatal(err)
+	}
+	scanTest(t, &fakePort{reply: answer(map[string]string{"SN ?": "S1", "MEA CH 1 ?": "20"})}, 1)
+	sock := sourceDB(t)
+
+	if err := scan(); err != nil {
+		t.Fatal(err)
+	}
+	if sources := dataColumn(t, sock, "source"); !slices.Equal(sources, []string{"import"}) {
+		t.Errorf("stored sources %q, want import", sources)
+	}
+
+	if err := loadTestConfig(t, `db.source = "replayed"`, `scanAddresses = "1"`); err == nil {
+		t.Error("unknown source tag accepted")
+	}
+}
+
+func TestImportStampsSource(t *testing.T) {
+	sock := sourceDB(t)
+	dir := t.TempDir()
+	setVar(t, &importDir, dir)
+	file := filepath.Join(dir, "backfill.csv")
+	rows := "S1,temperature,2026-10-01T12:00:00Z,20.5\nS2, temperature, 2026-10-01T12:00:00Z, 21\n"
+	if err := os.WriteFile(file, []byte(rows), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	importBackfill()
+	if sources := dataColumn(t, sock, "source"); !slices.Equal(sources, []string{"import", "import"}) {
+		t.Errorf("stored sources %q, want two import rows", sources)
+	}
+	if _, err := os.Stat(file + ".imported"); err != nil {
+		t.Errorf("imported file not renamed: %v", err)
+	}
+
+	// Imported files are not stored again
+	importBackfill()
+	if n := rowCount(t, sock, "data"); n != 2 {
+		t.Errorf("%d rows after a second import, want 2", n)
+	}
+}
+
+func TestImportMalformedFileKept(t *testing.T) {
+	sock := sourceDB(t)
+	dir := t.TempDir()
+	setVar(t, &importDir, dir)
+	file := filepath.Join(dir, "backfill.csv")
+	if err := os.WriteFile(file, []byte("S1,temperature,yesterday,20.5\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	importBackfill()
+	if n := rowCount(t, sock, "data"); n != 0 {
+		t.Errorf("%d rows stored from a malformed file, want 0", n)
+	}
+	if _, err := os.Stat(file); err != nil {
+		t.Errorf("malformed file renamed: %v", err)
+	}
+}
+
+// downStore fails writes with status 1 while down is set
+type downStore struct {
+	store
+	down bool
+}
+
+func (s *downStore) write(rec record) int {
+	if s.down {
+		return 1
+	}
+	return s.store.write(rec)
+}
+
+func TestReplayStampsSource(t *testing.T) {
+	sock := sourceDB(t)
+	down := &downStore{store: dbStore{}, down: true}
+	setVar(t, &dataStore, store(down))
+	setVar(t, &bufferSize, 2)
+	setVar(t, &buffered, nil)
+	setVar(t, &storedValues, 0)
+
+	for _, serial := range []string{"S1", "S2", "S1"} {
+		if status := storeRecord(record{Serial: serial, Value: "20", Type: "temperature", Source: "live"}); status != 1 {
+			t.Fatalf("storeRecord while down = %d, want 1", status)
+		}
+	}
+	if len(buffered) != 2 || buffered[0].Serial != "S2" {
+		t.Fatalf("buffered %v, want the two newest records", buffered)
+	}
+
+	down.down = false
+	if status := storeRecord(record{Serial: "S2", Value: "21", Type: "temperature", Source: "live"}); status != 0 {
+		t.Fatalf("storeRecord = %d, want 0", status)
+	}
+	// Ordered by channel: S1 replayed, S2 written live and then replayed
+	if sources := dataColumn(t, sock, "source"); !slices.Equal(sources, []string{"buffer", "live", "buffer"}) {
+		t.Errorf("stored sources %q, want buffer, live, buffer", sources)
+	}
+	if len(buffered) != 0 || storedValues != 2 {
+		t.Errorf("%d records still buffered, %d replays counted, want 0 and 2", len(buffered), storedValues)
+	}
+}
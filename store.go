@@ -0,0 +1,146 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+// store persists measurement records. write returns 0 on success or one of
+// the writeToDB status codes.
+type store interface {
+	write(rec record) int
+}
+
+// storeBackend selects the store: "db" or "http"
+var storeBackend = "db"
+
+var dataStore store = dbStore{}
+
+// dbStore writes to the configured SQL database
+type dbStore struct{}
+
+func (dbStore) write(rec record) int {
+	return writeToDB(rec)
+}
+
+// HTTP ingestion configuration
+var (
+	httpURL        string
+	httpAuth       string // Authorization header value, "" = none
+	httpTimeout    = 10 * time.Second
+	httpRetries    = 2
+	httpRetryDelay = time.Second // doubled on each retry
+)
+
+// Wait between POST retries, replaced in tests
+var httpSleep = time.Sleep
+
+// httpStore POSTs each record as a JSON object to an ingestion endpoint.
+// Network errors and 5xx responses are retried, other responses are final.
+type httpStore struct {
+	url        string
+	auth       string
+	retries    int
+	retryDelay time.Duration
+	client     *http.Client
+}
+
+func newHTTPStore() *httpStore {
+	return &httpStore{url: httpURL, auth: httpAuth, retries: httpRetries, retryDelay: httpRetryDelay,
+		client: &http.Client{Timeout: httpTimeout}}
+}
+
+// payload returns the JSON body of a record, with the field names of the
+// status output. A device status code is sent as status with its
+// description instead of a value.
+func (s *httpStore) payload(rec record, value any, isStatus bool) ([]byte, error) {
+	body := map[string]any{
+		jsonField("serial"):    rec.Serial,
+		jsonField("type"):      rec.Type,
+		jsonField("timestamp"): rec.Time,
+		jsonField("source"):    rec.Source,
+	}
+	if isStatus {
+		body[jsonField("status")] = value
+		body[jsonField("description")] = errorDescription(rec.Value)
+		return json.Marshal(body)
+	}
+	body[jsonField("value")] = value
+	body[jsonField("unit")] = rec.Unit
+	body[jsonField("quality")] = rec.Quality
+	body[jsonField("retries")] = rec.Retries
+	if storeIndex {
+		body[jsonField("index")] = rec.Index
+	}
+	if rec.Tag != "" {
+		body[jsonField("tag")] = rec.Tag
+	}
+	if rec.Raw != "" {
+		body[jsonField("raw")] = rec.Raw
+	}
+	return json.Marshal(body)
+}
+
+func (s *httpStore) write(rec record) int {
+	value, isStatus, ok := checkValue(rec)
+	if !ok {
+		return 6
+	}
+	body, err := s.payload(rec, value, isStatus)
+	if err != nil {
+		slog.Error("http store encoding failed", "serNoStr", rec.Serial, "error", err)
+		return 6
+	}
+
+	for try := 0; ; try++ {
+		status, err := s.post(body)
+		switch {
+		case err == nil && status < 300:
+			return 0
+		case err == nil && status < 500:
+			slog.Error("http store rejected record", "serNoStr", rec.Serial, "status", status)
+			return 5
+		case try >= s.retries:
+			slog.Error("http store failed", "serNoStr", rec.Serial, "tries", try+1, "status", status, "error", err)
+			return 1
+		}
+		slog.Debug("http store failed, retrying", "serNoStr", rec.Serial, "status", status, "error", err)
+		httpSleep(s.retryDelay << try)
+	}
+}
+
+// post sends one request and returns the response status
+func (s *httpStore) post(body []byte) (int, error) {
+	req, err := http.NewRequest(http.MethodPost, s.url, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if s.auth != "" {
+		req.Header.Set("Authorization", s.auth)
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	resp.Body.Close()
+	return resp.StatusCode, nil
+}
+
+// setupStore creates the configured store
+func setupStore() error {
+	switch storeBackend {
+	case "http":
+		if httpURL == "" {
+			return fmt.Errorf("store %q needs http.url", storeBackend)
+		}
+		dataStore = newHTTPStore()
+	default:
+		dataStore = dbStore{}
+	}
+	return nil
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+)
+
+var httpWaits []time.Duration
+
+// ingestServer answers with the given status codes in turn, 200 after
+// them, and records the requests
+type ingestServer struct {
+	mu       sync.Mutex
+	statuses []int
+	requests []*http.Request
+	bodies   [][]byte
+}
+
+func (s *ingestServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.requests = append(s.requests, r)
+	s.bodies = append(s.bodies, body)
+	status := http.StatusOK
+	if len(s.statuses) > 0 {
+		status, s.statuses = s.statuses[0], s.statuses[1:]
+	}
+	w.WriteHeader(status)
+}
+
+// httpTest starts an ingestion server and sets up the http store for it.
+// Retry waits are recorded in httpWaits instead of slept.
+func httpTest(t *testing.T, statuses ...int) (*ingestServer, store) {
+	t.Helper()
+	srv := &ingestServer{statuses: statuses}
+	ts := httptest.NewServer(srv)
+	t.Cleanup(ts.Close)
+	setVar(t, &storeBackend, "http")
+	setVar(t, &httpURL, ts.URL+"/ingest")
+	setVar(t, &httpAuth, "Bearer secret")
+	setVar(t, &httpRetries, 1)
+	setVar(t, &httpWaits, nil)
+	setVar(t, &httpSleep, func(d time.Duration) { httpWaits = append(httpWaits, d) })
+	setVar(t, &dataStore, dataStore)
+	if err := setupStore(); err != nil {
+		t.Fatal(err)
+	}
+	return srv, dataStore
+}
+
+func TestHTTPStorePayload(t *testing.T) {
+	srv, s := httpTest(t)
+	when := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	rec := record{Serial: "S1", Type: "temperature", Value: "20.5", Unit: "C", Time: when,
+		Quality: "normal", Source: "live", Tag: "room 1"}
+	if status := s.write(rec); status != 0 {
+		t.Fatalf("write = %d, want 0", status)
+	}
+
+	if len(srv.requests) != 1 {
+		t.Fatalf("%d requests, want 1", len(srv.requests))
+	}
+	r := srv.requests[0]
+	if r.Method != http.MethodPost || r.URL.Path != "/ingest" {
+		t.Errorf("request %s %s, want POST /ingest", r.Method, r.URL.Path)
+	}
+	if r.Header.Get("Content-Type") != "application/json" || r.Header.Get("Authorization") != "Bearer secret" {
+		t.Errorf("headers %v", r.Header)
+	}
+	var body map[string]any
+	if err := json.Unmarshal(srv.bodies[0], &body); err != nil {
+		t.Fatal(err)
+	}
+	for field, want := range map[string]any{
+		"serial": "S1", "type": "temperature", "value": "20.5", "unit": "C",
+		"timestamp": "2024-03-01T12:00:00Z", "quality": "normal", "retries": 0.0,
+		"source": "live", "tag": "room 1",
+	} {
+		if body[jsonField(field)] != want {
+			t.Errorf("%s = %v, want %v", field, body[jsonField(field)], want)
+		}
+	}
+	if _, ok := body[jsonField("raw")]; ok {
+		t.Error("raw sent for an unconverted value")
+	}
+}
+
+func TestHTTPStoreRetries(t *testing.T) {
+	for _, c := range []struct {
+		statuses []int
+		want     int
+		requests int
+	}{
+		{[]int{503}, 0, 2},      // retried, then accepted
+		{[]int{500, 502}, 1, 2}, // retries exhausted
+		{[]int{400}, 5, 1},      // rejected, not retried
+	} {
+		srv, s := httpTest(t, c.statuses...)
+		if status := s.write(record{Serial: "S1", Value: "1"}); status != c.want || len(srv.requests) != c.requests {
+			t.Errorf("answers %v: write = %d after %d requests, want %d after %d",
+				c.statuses, status, len(srv.requests), c.want, c.requests)
+		}
+	}
+}
+
+func TestHTTPStoreRetryDelay(t *testing.T) {
+	setVar(t, &httpRetryDelay, 3*time.Second)
+	srv, s := httpTest(t, 500, 500, 500)
+	setVar(t, &httpRetries, 2)
+	s = newHTTPStore()
+
+	if status := s.write(record{Serial: "S1", Value: "1"}); status != 1 || len(srv.requests) != 3 {
+		t.Errorf("write = %d after %d requests, want 1 after 3", status, len(srv.requests))
+	}
+	if want := []time.Duration{3 * time.Second, 6 * time.Second}; !slices.Equal(httpWaits, want) {
+		t.Errorf("waits %v, want %v", httpWaits, want)
+	}
+}
+
+func TestHTTPStoreValues(t *testing.T) {
+	srv, s := httpTest(t)
+	setVar(t, &valueType, "numeric")
+
+	// Values the value column cannot hold are not sent
+	if status := s.write(record{Serial: "S1", Type: "temperature", Value: "--"}); status != 6 {
+		t.Errorf("write of a non-numeric value = %d, want 6", status)
+	}
+	if len(srv.requests) != 0 {
+		t.Fatalf("%d requests for a non-numeric value, want 0", len(srv.requests))
+	}
+
+	if status := s.write(record{Serial: "S1", Type: "temperature", Value: " 20.5"}); status != 0 {
+		t.Errorf("write = %d, want 0", status)
+	}
+	// A device status code is sent as status, not as a measurement
+	if status := s.write(record{Serial: "S1", Type: "temperature", Value: "100001"}); status != 0 {
+		t.Errorf("write of a status code = %d, want 0", status)
+	}
+	if len(srv.requests) != 2 {
+		t.Fatalf("%d requests, want 2", len(srv.requests))
+	}
+
+	var value, status map[string]any
+	if err := json.Unmarshal(srv.bodies[0], &value); err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal(srv.bodies[1], &status); err != nil {
+		t.Fatal(err)
+	}
+	if value["value"] != 20.5 {
+		t.Errorf("value = %#v, want the number 20.5", value["value"])
+	}
+	if _, ok := status["value"]; ok || status["status"] != "100001" || status["description"] != errorDescription("100001") {
+		t.Errorf("status code sent as %v", status)
+	}
+}
+
+func TestHTTPStoreNeedsURL(t *testing.T) {
+	setVar(t, &storeBackend, "http")
+	setVar(t, &httpURL, "")
+	setVar(t, &dataStore, dataStore)
+	if err := setupStore(); err == nil {
+		t.Error("http store without http.url accepted")
+	}
+}
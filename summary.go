@@ -0,0 +1,54 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"log/slog"
+	"time"
+)
+
+// Run summary, printed as JSON on exit with -summary
+var (
+	printSummary bool
+	startTime    = time.Now()
+	measureOK    [MAXNUMADR]int64
+	measureFail  [MAXNUMADR]int64
+	storedValues int64
+)
+
+// countMeasurement records the outcome of a measurement of the address at
+// index idx
+func countMeasurement(idx int, ok bool) {
+	if ok {
+		measureOK[idx]++
+	} else {
+		measureFail[idx]++
+	}
+}
+
+// writeSummary writes the totals of the run as one JSON object
+func writeSummary(w io.Writer) {
+	type addrSummary struct {
+		Address byte  `json:"address"`
+		Success int64 `json:"success"`
+		Failure int64 `json:"failure"`
+	}
+	summary := struct {
+		Scans          int64         `json:"scans"`
+		Stored         int64         `json:"stored"`
+		RuntimeSeconds float64       `json:"runtimeSeconds"`
+		Addresses      []addrSummary `json:"addresses"`
+	}{
+		Scans:          scansDone.Load(),
+		Stored:         storedValues,
+		RuntimeSeconds: time.Since(startTime).Seconds(),
+		Addresses:      make([]addrSummary, numAdresses),
+	}
+	for i := range summary.Addresses {
+		summary.Addresses[i] = addrSummary{scanAddress[i], measureOK[i], measureFail[i]}
+	}
+
+	if err := json.NewEncoder(w).Encode(summary); err != nil {
+		slog.Error("summary encoding failed", "error", err)
+	}
+}
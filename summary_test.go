@@ -0,0 +1,56 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+)
+
+func TestSummaryAggregates(t *testing.T) {
+	port := &fakePort{reply: func(adr byte, cmd string) []byte {
+		switch {
+		case cmd == "SN ?":
+			return frame(ACK, "S1")
+		case adr == 1:
+			return frame(ACK, "20")
+		}
+		return nil // address 2 never answers the measurement
+	}}
+	stored := scanTest(t, port, 1, 2)
+	setVar(t, &storedValues, 0)
+	scans := scansDone.Load()
+	t.Cleanup(func() { scansDone.Store(scans) })
+	scansDone.Store(0)
+
+	for i := 0; i < 3; i++ {
+		scan()
+	}
+
+	var buf bytes.Buffer
+	writeSummary(&buf)
+	var summary struct {
+		Scans          int64
+		Stored         int64
+		RuntimeSeconds float64
+		Addresses      []struct {
+			Address byte
+			Success int64
+			Failure int64
+		}
+	}
+	if err := json.Unmarshal(buf.Bytes(), &summary); err != nil {
+		t.Fatalf("%s: %v", buf.Bytes(), err)
+	}
+	if n := int64(len(stored.records())); summary.Scans != 3 || summary.Stored != n || summary.RuntimeSeconds <= 0 {
+		t.Errorf("summary %s, want 3 scans, %d stored values and the runtime", buf.Bytes(), n)
+	}
+	if len(summary.Addresses) != 2 {
+		t.Fatalf("summary of %d addresses, want 2", len(summary.Addresses))
+	}
+	for i, want := range [][2]int64{{3, 0}, {0, 3}} {
+		a := summary.Addresses[i]
+		if a.Address != byte(i+1) || a.Success != want[0] || a.Failure != want[1] {
+			t.Errorf("address %d: %+v, want %d successes and %d failures", i+1, a, want[0], want[1])
+		}
+	}
+}
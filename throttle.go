@@ -0,0 +1,146 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+var logThrottle time.Duration // 0 = no throttling of repeated messages
+
+// throttleKeyAttrs are the attributes telling apart otherwise identical
+// messages, e.g. the same error on two addresses. Other attributes such as
+// counts, durations or values change between repeats and are ignored.
+var throttleKeyAttrs = []string{"address", "device", "driver", "serial", "serNoStr"}
+
+// throttled tracks one distinct warning or error message
+type throttled struct {
+	record     slog.Record
+	handler    slog.Handler
+	since      time.Time
+	suppressed int
+}
+
+type throttleState struct {
+	mu     sync.Mutex
+	window time.Duration
+	seen   map[string]*throttled
+}
+
+// throttleHandler logs the first of identical warnings and errors right
+// away and collapses further ones within the window into a summary.
+type throttleHandler struct {
+	next   slog.Handler
+	prefix string // identifying attributes added with WithAttrs, part of the key
+	state  *throttleState
+}
+
+func newThrottleHandler(next slog.Handler, window time.Duration) *throttleHandler {
+	h := &throttleHandler{next: next, state: &throttleState{window: window, seen: make(map[string]*throttled)}}
+	go func() {
+		ticker := time.NewTicker(window)
+		defer ticker.Stop()
+		for range ticker.C {
+			h.state.flush(time.Now())
+		}
+	}()
+	return h
+}
+
+func (h *throttleHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return h.next.Enabled(ctx, level)
+}
+
+func (h *throttleHandler) Handle(ctx context.Context, r slog.Record) error {
+	if r.Level < slog.LevelWarn {
+		return h.next.Handle(ctx, r)
+	}
+
+	key := h.key(r)
+	now := time.Now()
+
+	s := h.state
+	s.mu.Lock()
+	t, ok := s.seen[key]
+	if ok && now.Sub(t.since) < s.window {
+		t.suppressed++
+		s.mu.Unlock()
+		return nil
+	}
+	var summary *throttled
+	if ok && t.suppressed > 0 {
+		summary = t
+	}
+	s.seen[key] = &throttled{record: r.Clone(), handler: h.next, since: now}
+	s.mu.Unlock()
+
+	if summary != nil {
+		summary.emit(ctx, now)
+	}
+	return h.next.Handle(ctx, r)
+}
+
+// key identifies identical messages by level, text and identifying
+// attributes
+func (h *throttleHandler) key(r slog.Record) string {
+	var b strings.Builder
+	b.WriteString(h.prefix)
+	fmt.Fprintf(&b, "%s|%s", r.Level, r.Message)
+	r.Attrs(func(a slog.Attr) bool {
+		if slices.Contains(throttleKeyAttrs, a.Key) {
+			fmt.Fprintf(&b, "|%s", a)
+		}
+		return true
+	})
+	return b.String()
+}
+
+func (h *throttleHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	prefix := h.prefix
+	for _, a := range attrs {
+		if slices.Contains(throttleKeyAttrs, a.Key) {
+			prefix += a.String() + "|"
+		}
+	}
+	return &throttleHandler{next: h.next.WithAttrs(attrs), prefix: prefix, state: h.state}
+}
+
+func (h *throttleHandler) WithGroup(name string) slog.Handler {
+	return &throttleHandler{next: h.next.WithGroup(name), prefix: h.prefix + name + ".", state: h.state}
+}
+
+// flush summarizes the messages whose window has passed
+func (s *throttleState) flush(now time.Time) {
+	var summaries []*throttled
+
+	s.mu.Lock()
+	for key, t := range s.seen {
+		if now.Sub(t.since) < s.window {
+			continue
+		}
+		if t.suppressed > 0 {
+			summaries = append(summaries, t)
+		}
+		delete(s.seen, key)
+	}
+	s.mu.Unlock()
+
+	for _, t := range summaries {
+		t.emit(context.Background(), now)
+	}
+}
+
+// emit logs how often a message was suppressed
+func (t *throttled) emit(ctx context.Context, now time.Time) {
+	r := slog.NewRecord(now, t.record.Level, t.record.Message+" (repeated)", 0)
+	t.record.Attrs(func(a slog.Attr) bool {
+		r.AddAttrs(a)
+		return true
+	})
+	r.AddAttrs(slog.Int("repeated", t.suppressed), slog.Duration("window", now.Sub(t.since)))
+	t.handler.Handle(ctx, r)
+}
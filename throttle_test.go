@@ -0,0 +1,86 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"log/slog"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestThrottleCollapsesRepeats(t *testing.T) {
+	var buf bytes.Buffer
+	h := newThrottleHandler(slog.NewTextHandler(&buf, nil), time.Hour)
+	log := slog.New(h)
+
+	for i := 0; i < 5; i++ {
+		log.Error("read failed", "address", 3)
+	}
+	log.Error("read failed", "address", 4)
+	log.Info("scan done")
+	log.Info("scan done")
+
+	out := buf.String()
+	if n := strings.Count(out, `msg="read failed" address=3`); n != 1 {
+		t.Errorf("repeated error logged %d times within the window, want once:\n%s", n, out)
+	}
+	if !strings.Contains(out, `msg="read failed" address=4`) || strings.Count(out, "scan done") != 2 {
+		t.Errorf("distinct errors or info records throttled:\n%s", out)
+	}
+
+	buf.Reset()
+	h.state.flush(time.Now().Add(time.Hour))
+	out = buf.String()
+	if !strings.Contains(out, `msg="read failed (repeated)" address=3 repeated=4`) {
+		t.Errorf("summary %q, want the 4 suppressed errors of address 3", out)
+	}
+	if strings.Contains(out, "address=4") {
+		t.Errorf("summary of a message logged once: %q", out)
+	}
+}
+
+func TestThrottleIgnoresChangingAttrs(t *testing.T) {
+	var buf bytes.Buffer
+	h := newThrottleHandler(slog.NewTextHandler(&buf, nil), time.Hour)
+	log := slog.New(h).With("device", "/dev/ttyUSB0")
+
+	for i := 1; i <= 5; i++ {
+		log.Warn("scan failed", "address", 3, "failures", i, "elapsed", time.Duration(i)*time.Second)
+	}
+	log.Warn("scan failed", "address", 4, "failures", 1)
+
+	out := buf.String()
+	if n := strings.Count(out, "address=3"); n != 1 {
+		t.Errorf("repeats differing in counts logged %d times, want once:\n%s", n, out)
+	}
+	if !strings.Contains(out, "address=4") {
+		t.Errorf("another address throttled:\n%s", out)
+	}
+	buf.Reset()
+	h.state.flush(time.Now().Add(time.Hour))
+	if out := buf.String(); !strings.Contains(out, "address=3 failures=1") || !strings.Contains(out, "repeated=4") {
+		t.Errorf("summary %q, want the first record with 4 repeats", out)
+	}
+}
+
+// Database write failures are logged at a level the throttle handles
+func TestDBWriteErrorsThrottled(t *testing.T) {
+	setVar(t, &dbDriver, "sqlite")
+	setVar(t, &db, DBAccessData{Name: filepath.Join(t.TempDir(), "missing", "test.db")})
+	var buf bytes.Buffer
+	logger := slog.New(newThrottleHandler(slog.NewTextHandler(&buf, nil), time.Hour))
+	prev := slog.Default()
+	slog.SetDefault(logger)
+	t.Cleanup(func() { slog.SetDefault(prev) })
+
+	for i := 0; i < 3; i++ {
+		if status := writeToDB(record{Serial: fmt.Sprint("S", i), Value: "20", Type: "temperature"}); status != 1 {
+			t.Fatalf("writeToDB = %d, want a connect failure", status)
+		}
+	}
+	if n := strings.Count(buf.String(), `level=ERROR msg="database connection failed"`); n != 1 {
+		t.Errorf("connect failure logged %d times, want once:\n%s", n, buf.String())
+	}
+}
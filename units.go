@@ -0,0 +1,118 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Temperature unit conversion. Values are converted to unitTarget before
+// they are stored; the unit reported by the device is used as the source
+// and unitSource is assumed for devices that report none.
+var (
+	unitSource    string // "C", "F" or "K"; "" = convert reported units only
+	unitTarget    string // "" = no conversion
+	storeRawValue = false
+)
+
+var (
+	rawValue  [MAXNUMADR]string // last value before the conversion, "" = not converted
+	valueUnit [MAXNUMADR]string // unit of the last value after the conversion
+)
+
+// normalizeUnit maps the spellings of a temperature unit to "C", "F" or
+// "K". Other units return "".
+func normalizeUnit(unit string) string {
+	switch strings.ToLower(strings.TrimSpace(unit)) {
+	case "c", "°c", "degc", "celsius":
+		return "C"
+	case "f", "°f", "degf", "fahrenheit":
+		return "F"
+	case "k", "kelvin":
+		return "K"
+	}
+	return ""
+}
+
+// convertTemperature converts v between the units "C", "F" and "K". The
+// result is rounded to 9 decimals so exact conversions such as 25 °C to
+// 77 °F do not carry binary floating point noise.
+func convertTemperature(v float64, from, to string) (float64, error) {
+	var celsius float64
+	switch from {
+	case "C":
+		celsius = v
+	case "F":
+		celsius = (v - 32) * 5 / 9
+	case "K":
+		celsius = v - 273.15
+	default:
+		return 0, fmt.Errorf("unknown unit %q", from)
+	}
+
+	var result float64
+	switch to {
+	case "C":
+		result = celsius
+	case "F":
+		result = celsius*9/5 + 32
+	case "K":
+		result = celsius + 273.15
+	default:
+		return 0, fmt.Errorf("unknown unit %q", to)
+	}
+	return math.Round(result*1e9) / 1e9, nil
+}
+
+// convertUnit converts the value of rec to the target unit and records the
+// target unit. Status codes, non numeric values and values of unknown unit
+// are returned unchanged.
+func convertUnit(rec record) record {
+	if unitTarget == "" || isStatusCode(rec.Value) {
+		return rec
+	}
+	from := normalizeUnit(rec.Unit)
+	if from == "" && rec.Unit == "" {
+		from = unitSource
+	}
+	if from == "" {
+		slog.Warn("unit conversion skipped, unknown source unit", "serial", rec.Serial, "unit", rec.Unit)
+		return rec
+	}
+	v, err := strconv.ParseFloat(strings.TrimSpace(rec.Value), 64)
+	if err != nil {
+		return rec
+	}
+	converted, err := convertTemperature(v, from, unitTarget)
+	if err != nil {
+		return rec
+	}
+
+	rec.Raw = rec.Value
+	rec.Value = strconv.FormatFloat(converted, 'f', -1, 64)
+	rec.Unit = unitTarget
+	return rec
+}
+
+// convertMeasured converts the last value of the address at index idx in
+// place, so the stored rows, the extremes and the metrics all carry the
+// same value. The values of a vector response are converted one by one.
+func convertMeasured(idx int) {
+	rawValue[idx], valueUnit[idx] = "", deviceUnit[idx]
+	values := []string{valueStr[idx]}
+	if vectorModes[scanAddress[idx]] != "" {
+		values = splitVector(valueStr[idx])
+	}
+	converted := false
+	for i, v := range values {
+		rec := convertUnit(record{Serial: serNoStr[idx], Value: v, Unit: deviceUnit[idx]})
+		values[i] = rec.Value
+		converted = converted || rec.Raw != ""
+	}
+	if converted {
+		rawValue[idx], valueUnit[idx] = valueStr[idx], unitTarget
+		valueStr[idx] = strings.Join(values, ",")
+	}
+}
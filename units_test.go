@@ -0,0 +1,77 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestConvertTemperaturePairs(t *testing.T) {
+	// The same temperature in each unit
+	points := []map[string]float64{
+		{"C": 0, "F": 32, "K": 273.15},
+		{"C": 100, "F": 212, "K": 373.15},
+		{"C": -40, "F": -40, "K": 233.15},
+		{"C": 25, "F": 77, "K": 298.15},
+	}
+	for _, p := range points {
+		for from, v := range p {
+			for to, want := range p {
+				got, err := convertTemperature(v, from, to)
+				if err != nil || got != want {
+					t.Errorf("convertTemperature(%v, %s, %s) = %v, %v; want %v", v, from, to, got, err, want)
+				}
+			}
+		}
+	}
+	if _, err := convertTemperature(1, "C", "X"); err == nil {
+		t.Error("conversion to an unknown unit succeeded")
+	}
+	if _, err := convertTemperature(1, "R", "C"); err == nil {
+		t.Error("conversion from an unknown unit succeeded")
+	}
+}
+
+// The converted value is the one stored, tracked and published
+func TestConvertedOnce(t *testing.T) {
+	port := &fakePort{reply: answer(map[string]string{"SN ?": "S1", "UNIT ?": "degC", "MEA CH 1 ?": "25"})}
+	stored := scanTest(t, port, 1)
+	setVar(t, &unitCommand, "UNIT ?")
+	setVar(t, &unitTarget, "F")
+
+	scan()
+	recs := stored.records()
+	if len(recs) != 1 {
+		t.Fatalf("stored %d records, want 1", len(recs))
+	}
+	if r := recs[0]; r.Value != "77" || r.Unit != "F" || r.Raw != "25" {
+		t.Errorf("stored value %q %q raw %q, want 77 F raw 25", r.Value, r.Unit, r.Raw)
+	}
+	if e := extremes[0]; !e.Valid || e.Min != 77 || e.Max != 77 {
+		t.Errorf("extremes %+v, want 77", e)
+	}
+	m := metricsAddrs[0]
+	if m.Value != "77" || m.Unit != "F" {
+		t.Errorf("published value %q %q, want 77 F", m.Value, m.Unit)
+	}
+}
+
+func TestConvertedVector(t *testing.T) {
+	port := &fakePort{reply: answer(map[string]string{"SN ?": "S1", "MEA CH 1 ?": "0;100"})}
+	stored := scanTest(t, port, 1)
+	setVar(t, &unitSource, "C")
+	setVar(t, &unitTarget, "K")
+	setVar(t, &vectorModes, map[byte]string{1: "rows"})
+
+	scan()
+	recs := stored.records()
+	if len(recs) != 2 {
+		t.Fatalf("stored %d records, want 2", len(recs))
+	}
+	for i, want := range []string{"273.15", "373.15"} {
+		if recs[i].Value != want || recs[i].Raw != []string{"0", "100"}[i] {
+			t.Errorf("row %d = %q raw %q, want %s", i, recs[i].Value, recs[i].Raw, want)
+		}
+	}
+	if valueStr[0] != "273.15,373.15" {
+		t.Errorf("published vector %q", valueStr[0])
+	}
+}
@@ -0,0 +1,306 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"slices"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"unicode"
+)
+
+// Value validators, boolean expressions over the measured value such as
+// "value > -50 && value < 150". Readings failing the expression of their
+// address, or the default one, are not stored.
+var (
+	validateDefault   *validator
+	validateByAddress = make(map[byte]*validator)
+	rejectedValues    atomic.Int64
+)
+
+// validator is a compiled expression
+type validator struct {
+	expr string
+	eval func(value float64) float64 // booleans are 1 and 0
+}
+
+// validValue reports whether the value measured on the address at index
+// idx passes its validator. Status codes and non numeric values pass.
+func validValue(idx int, value string) bool {
+	v, ok := validateByAddress[scanAddress[idx]]
+	if !ok {
+		v = validateDefault
+	}
+	if v == nil || isStatusCode(value) {
+		return true
+	}
+	f, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+	if err != nil {
+		return true
+	}
+	if v.eval(f) != 0 {
+		return true
+	}
+	rejectedValues.Add(1)
+	slog.Warn("value rejected by validator", "address", scanAddress[idx], "value", value, "validate", v.expr)
+	return false
+}
+
+// parseValidator compiles a validator expression. The grammar knows the
+// variable "value", numbers, + - * /, the comparisons < <= > >= == != and
+// the logical operators && || ! with parentheses. The expression must be a
+// condition, not a number.
+func parseValidator(expr string) (*validator, error) {
+	tokens, err := tokenize(expr)
+	if err != nil {
+		return nil, err
+	}
+	p := &exprParser{tokens: tokens}
+	n, err := p.or()
+	if err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.tokens) {
+		return nil, fmt.Errorf("unexpected %q in %q", p.tokens[p.pos], expr)
+	}
+	if !n.bool {
+		return nil, fmt.Errorf("%q is not a condition", expr)
+	}
+	return &validator{expr: expr, eval: n.eval}, nil
+}
+
+// tokenize splits an expression into numbers, names and operators
+func tokenize(expr string) ([]string, error) {
+	var tokens []string
+	for i := 0; i < len(expr); {
+		c := rune(expr[i])
+		switch {
+		case unicode.IsSpace(c):
+			i++
+		case unicode.IsDigit(c) || c == '.':
+			j := i
+			for j < len(expr) && (unicode.IsDigit(rune(expr[j])) || expr[j] == '.' || expr[j] == 'e' ||
+				((expr[j] == '-' || expr[j] == '+') && expr[j-1] == 'e')) {
+				j++
+			}
+			tokens = append(tokens, expr[i:j])
+			i = j
+		case unicode.IsLetter(c):
+			j := i
+			for j < len(expr) && unicode.IsLetter(rune(expr[j])) {
+				j++
+			}
+			tokens = append(tokens, expr[i:j])
+			i = j
+		case strings.ContainsRune("<>=!&|", c):
+			if i+1 < len(expr) && slices.Contains([]string{"<=", ">=", "==", "!=", "&&", "||"}, expr[i:i+2]) {
+				tokens = append(tokens, expr[i:i+2])
+				i += 2
+			} else if strings.ContainsRune("<>!", c) {
+				tokens = append(tokens, expr[i:i+1])
+				i++
+			} else {
+				return nil, fmt.Errorf("invalid operator at %q", expr[i:])
+			}
+		case strings.ContainsRune("+-*/()", c):
+			tokens = append(tokens, expr[i:i+1])
+			i++
+		default:
+			return nil, fmt.Errorf("invalid character %q in %q", c, expr)
+		}
+	}
+	return tokens, nil
+}
+
+// exprNode is a parsed subexpression and whether it is a condition
+type exprNode struct {
+	eval func(value float64) float64
+	bool bool
+}
+
+type exprParser struct {
+	tokens []string
+	pos    int
+}
+
+func (p *exprParser) peek() string {
+	if p.pos < len(p.tokens) {
+		return p.tokens[p.pos]
+	}
+	return ""
+}
+
+func (p *exprParser) or() (exprNode, error) {
+	left, err := p.and()
+	for err == nil && p.peek() == "||" {
+		p.pos++
+		var right exprNode
+		if right, err = p.and(); err == nil {
+			left, err = logical("||", left, right, func(a, b bool) bool { return a || b })
+		}
+	}
+	return left, err
+}
+
+func (p *exprParser) and() (exprNode, error) {
+	left, err := p.not()
+	for err == nil && p.peek() == "&&" {
+		p.pos++
+		var right exprNode
+		if right, err = p.not(); err == nil {
+			left, err = logical("&&", left, right, func(a, b bool) bool { return a && b })
+		}
+	}
+	return left, err
+}
+
+func (p *exprParser) not() (exprNode, error) {
+	if p.peek() != "!" {
+		return p.comparison()
+	}
+	p.pos++
+	n, err := p.not()
+	if err != nil {
+		return n, err
+	}
+	if !n.bool {
+		return n, fmt.Errorf("! needs a condition")
+	}
+	return exprNode{eval: func(v float64) float64 { return boolValue(n.eval(v) == 0) }, bool: true}, nil
+}
+
+func (p *exprParser) comparison() (exprNode, error) {
+	left, err := p.sum()
+	if err != nil {
+		return left, err
+	}
+	var cmp func(a, b float64) bool
+	op := p.peek()
+	switch op {
+	case "<":
+		cmp = func(a, b float64) bool { return a < b }
+	case "<=":
+		cmp = func(a, b float64) bool { return a <= b }
+	case ">":
+		cmp = func(a, b float64) bool { return a > b }
+	case ">=":
+		cmp = func(a, b float64) bool { return a >= b }
+	case "==":
+		cmp = func(a, b float64) bool { return a == b }
+	case "!=":
+		cmp = func(a, b float64) bool { return a != b }
+	default:
+		return left, nil
+	}
+	p.pos++
+	right, err := p.sum()
+	if err != nil {
+		return right, err
+	}
+	if left.bool || right.bool {
+		return left, fmt.Errorf("%s needs numbers", op)
+	}
+	return exprNode{eval: func(v float64) float64 { return boolValue(cmp(left.eval(v), right.eval(v))) }, bool: true}, nil
+}
+
+func (p *exprParser) sum() (exprNode, error) {
+	left, err := p.product()
+	for err == nil && (p.peek() == "+" || p.peek() == "-") {
+		op := p.peek()
+		p.pos++
+		var right exprNode
+		if right, err = p.product(); err == nil {
+			left, err = arithmetic(op, left, right)
+		}
+	}
+	return left, err
+}
+
+func (p *exprParser) product() (exprNode, error) {
+	left, err := p.unary()
+	for err == nil && (p.peek() == "*" || p.peek() == "/") {
+		op := p.peek()
+		p.pos++
+		var right exprNode
+		if right, err = p.unary(); err == nil {
+			left, err = arithmetic(op, left, right)
+		}
+	}
+	return left, err
+}
+
+func (p *exprParser) unary() (exprNode, error) {
+	if p.peek() != "-" {
+		return p.primary()
+	}
+	p.pos++
+	n, err := p.unary()
+	if err != nil {
+		return n, err
+	}
+	if n.bool {
+		return n, fmt.Errorf("- needs a number")
+	}
+	return exprNode{eval: func(v float64) float64 { return -n.eval(v) }}, nil
+}
+
+func (p *exprParser) primary() (exprNode, error) {
+	tok := p.peek()
+	p.pos++
+	switch {
+	case tok == "":
+		return exprNode{}, fmt.Errorf("unexpected end of expression")
+	case tok == "value":
+		return exprNode{eval: func(v float64) float64 { return v }}, nil
+	case tok == "(":
+		n, err := p.or()
+		if err != nil {
+			return n, err
+		}
+		if p.peek() != ")" {
+			return n, fmt.Errorf("missing )")
+		}
+		p.pos++
+		return n, nil
+	}
+	num, err := strconv.ParseFloat(tok, 64)
+	if err != nil {
+		return exprNode{}, fmt.Errorf("unknown name %q", tok)
+	}
+	return exprNode{eval: func(float64) float64 { return num }}, nil
+}
+
+// logical combines two conditions
+func logical(op string, left, right exprNode, f func(a, b bool) bool) (exprNode, error) {
+	if !left.bool || !right.bool {
+		return left, fmt.Errorf("%s needs conditions", op)
+	}
+	return exprNode{eval: func(v float64) float64 { return boolValue(f(left.eval(v) != 0, right.eval(v) != 0)) }, bool: true}, nil
+}
+
+// arithmetic combines two numbers
+func arithmetic(op string, left, right exprNode) (exprNode, error) {
+	if left.bool || right.bool {
+		return left, fmt.Errorf("%s needs numbers", op)
+	}
+	var f func(a, b float64) float64
+	switch op {
+	case "+":
+		f = func(a, b float64) float64 { return a + b }
+	case "-":
+		f = func(a, b float64) float64 { return a - b }
+	case "*":
+		f = func(a, b float64) float64 { return a * b }
+	default:
+		f = func(a, b float64) float64 { return a / b }
+	}
+	return exprNode{eval: func(v float64) float64 { return f(left.eval(v), right.eval(v)) }}, nil
+}
+
+func boolValue(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
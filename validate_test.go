@@ -0,0 +1,94 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestValidatorExpressions(t *testing.T) {
+	for expr, cases := range map[string]map[float64]bool{
+		"value > -50 && value < 150":                 {-50: false, -49.5: true, 20: true, 150: false},
+		"value >= 0 || value == -99":                 {0: true, -1: false, -99: true},
+		"!(value < 10)":                              {9.9: false, 10: true},
+		"value * 2 - 1 <= 2e1":                       {10.5: true, 10.6: false},
+		"(value + 1) / 2 != 3":                       {5: false, 4: true},
+		"value < 100 && !(value > 40 && value < 60)": {50: false, 30: true, 100: false},
+	} {
+		v, err := parseValidator(expr)
+		if err != nil {
+			t.Errorf("parseValidator(%q): %v", expr, err)
+			continue
+		}
+		for value, want := range cases {
+			if got := v.eval(value) != 0; got != want {
+				t.Errorf("%q with value %v = %v, want %v", expr, value, got, want)
+			}
+		}
+	}
+}
+
+func TestValidatorSyntaxErrors(t *testing.T) {
+	for _, expr := range []string{
+		"",
+		"value",
+		"value + 1",
+		"value > ",
+		"value >> 1",
+		"(value > 1",
+		"value > 1)",
+		"temp > 1",
+		"value > 1 & value < 2",
+		"value > 1 && 5",
+		"(value > 1) + 1",
+		"value = 1",
+		"value > 1 ; value < 2",
+	} {
+		if _, err := parseValidator(expr); err == nil {
+			t.Errorf("parseValidator(%q) succeeded", expr)
+		}
+	}
+}
+
+func TestValidatorRejects(t *testing.T) {
+	setAddresses(t, 1, 2)
+	setVar(t, &validateByAddress, map[byte]*validator{})
+	setVar(t, &validateDefault, nil)
+	rejected := rejectedValues.Load()
+	t.Cleanup(func() { rejectedValues.Store(rejected) })
+	rejectedValues.Store(0)
+
+	if err := loadTestConfig(t, `validate = "value > -50 && value < 150"`, `validate.2 = "value >= 0"`,
+		`scanAddresses = "1, 2"`); err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range []struct {
+		idx   int
+		value string
+		want  bool
+	}{
+		{0, "20", true},
+		{0, "-60", false},
+		{0, "200", false},
+		{1, "-10", false}, // the address validator replaces the default
+		{1, "200", true},
+		{0, "100001", true}, // status code
+		{0, "n/a", true},
+	} {
+		if got := validValue(c.idx, c.value); got != c.want {
+			t.Errorf("validValue(%d, %q) = %v, want %v", c.idx, c.value, got, c.want)
+		}
+	}
+	if n := rejectedValues.Load(); n != 3 {
+		t.Errorf("%d values rejected, want 3", n)
+	}
+}
+
+func TestValidatorFailsAtLoad(t *testing.T) {
+	setVar(t, &validateByAddress, map[byte]*validator{})
+	setVar(t, &validateDefault, nil)
+	for _, line := range []string{`validate = "value >"`, `validate.3 = "value + 1"`, `validate.x = "value > 1"`} {
+		err := loadTestConfig(t, line, `scanAddresses = "1"`)
+		if err == nil {
+			t.Errorf("loadConfig with %s succeeded", line)
+		}
+	}
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"encoding/json"
+	"strconv"
+	"strings"
+)
+
+// Vector responses carry several values, e.g. one per thermocouple junction.
+// "rows" stores one row per value with its index, "json" stores the values
+// as one JSON array.
+var (
+	vectorModes = make(map[byte]string)
+	storeIndex  = false
+)
+
+// splitVector splits a vector response into its values
+func splitVector(value string) []string {
+	return strings.FieldsFunc(value, func(r rune) bool {
+		return r == ',' || r == ';' || r == ' ' || r == '\t'
+	})
+}
+
+// vectorRecords expands the record of the address at index idx according
+// to its vector mode. Scalar addresses return the record unchanged.
+func vectorRecords(idx int, rec record) []record {
+	switch vectorModes[scanAddress[idx]] {
+	case "rows":
+		values := splitVector(rec.Value)
+		raws := splitVector(rec.Raw)
+		recs := make([]record, len(values))
+		for i, v := range values {
+			recs[i] = rec
+			recs[i].Value = v
+			recs[i].Index = i
+			if len(raws) == len(values) {
+				recs[i].Raw = raws[i]
+			}
+		}
+		return recs
+	case "json":
+		values := splitVector(rec.Value)
+		array := make([]any, len(values))
+		for i, v := range values {
+			if f, err := strconv.ParseFloat(v, 64); err == nil {
+				array[i] = f
+			} else {
+				array[i] = v
+			}
+		}
+		b, _ := json.Marshal(array)
+		rec.Value = string(b)
+		return []record{rec}
+	default:
+		return []record{rec}
+	}
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestVectorRows(t *testing.T) {
+	setAddresses(t, 1, 2)
+	setVar(t, &vectorModes, map[byte]string{1: "rows", 2: "json"})
+
+	base := record{Serial: "S1", Value: "21.5, 22.0;23.25 n/a", Type: "temperature"}
+	recs := vectorRecords(0, base)
+	want := []string{"21.5", "22.0", "23.25", "n/a"}
+	if len(recs) != len(want) {
+		t.Fatalf("%d records, want %d", len(recs), len(want))
+	}
+	for i, rec := range recs {
+		if rec.Index != i || rec.Value != want[i] || rec.Serial != "S1" || rec.Type != "temperature" {
+			t.Errorf("record %d = %+v, want value %s at index %d", i, rec, want[i], i)
+		}
+	}
+
+	recs = vectorRecords(1, base)
+	if len(recs) != 1 || recs[0].Value != `[21.5,22,23.25,"n/a"]` {
+		t.Errorf("json records %+v, want one JSON array", recs)
+	}
+}
+
+func TestScalarUnchanged(t *testing.T) {
+	setAddresses(t, 1)
+	setVar(t, &vectorModes, map[byte]string{})
+	rec := record{Serial: "S1", Value: "1,2"}
+	if recs := vectorRecords(0, rec); len(recs) != 1 || recs[0] != rec {
+		t.Errorf("scalar address records %+v, want the record unchanged", recs)
+	}
+}
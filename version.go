@@ -0,0 +1,38 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"runtime"
+	"runtime/debug"
+)
+
+// Build information, set with
+// -ldflags "-X main.version=1.2.3 -X main.commit=abc123"
+var (
+	version = "dev"
+	commit  = ""
+)
+
+var exposeBuildInfo = false // sensor_build_info on the metrics endpoint
+
+// buildCommit returns the commit the binary was built from, taken from the
+// VCS stamp of the Go toolchain unless set at link time
+func buildCommit() string {
+	if commit != "" {
+		return commit
+	}
+	if info, ok := debug.ReadBuildInfo(); ok {
+		for _, s := range info.Settings {
+			if s.Key == "vcs.revision" {
+				return s.Value
+			}
+		}
+	}
+	return "unknown"
+}
+
+// printVersion writes the -version output
+func printVersion(w io.Writer) {
+	fmt.Fprintf(w, "tempreg %s (commit %s, %s)\n", version, buildCommit(), runtime.Version())
+}